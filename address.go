@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// base58check appends the 4 byte Hash256 checksum to version || payload and
+// base58 encodes the result.
+func base58check(version byte, payload []byte) string {
+	b := append([]byte{version}, payload...)
+	checksum := Hash256(b)
+	return b58encode(append(b, checksum[:4]...))
+}
+
+// Version bytes of base58 addresses.
+var p2pkh_version = map[string]byte{"main": 0x00, "test": 0x6f}
+var p2sh_version = map[string]byte{"main": 0x05, "test": 0xc4}
+
+// ScriptAddress returns the base58 P2SH address paying to script.
+func ScriptAddress(net string, script Script) (string, error) {
+	version, ok := p2sh_version[net]
+	if !ok {
+		return "", fmt.Errorf("unknown network %q", net)
+	}
+	return base58check(version, Hash160(script_body(script))), nil
+}
+
+const bech32_charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32m_const replaces the final xor constant 1 of bech32 for witness
+// versions 1 and up (BIP350).
+const bech32m_const = 0x2bc830a3
+
+func bech32_polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		b := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (b>>i)&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32_hrp_expand(hrp string) []byte {
+	var out []byte
+	for _, c := range hrp {
+		out = append(out, byte(c)>>5)
+	}
+	out = append(out, 0)
+	for _, c := range hrp {
+		out = append(out, byte(c)&31)
+	}
+	return out
+}
+
+// convert_bits regroups from-bit groups into to-bit groups. With pad the
+// last group is padded with zeros, without it leftover bits must be zero
+// padding and are dropped.
+func convert_bits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	var acc, bits uint
+	var out []byte
+	for _, b := range data {
+		if uint(b)>>from != 0 {
+			return nil, fmt.Errorf("value %v does not fit in %v bits", b, from)
+		}
+		acc = acc<<from | uint(b)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&(1<<to-1)))
+		}
+	}
+	if pad && bits > 0 {
+		out = append(out, byte(acc<<(to-bits)&(1<<to-1)))
+	} else if !pad && (bits >= from || acc&(1<<bits-1) != 0) {
+		return nil, fmt.Errorf("invalid padding")
+	}
+	return out, nil
+}
+
+// SegwitAddress returns the bech32 (version 0) or bech32m (version 1 and up)
+// address of a witness program.
+func SegwitAddress(net string, version int, program []byte) (string, error) {
+	hrp, ok := segwit_hrp[net]
+	if !ok {
+		return "", fmt.Errorf("unknown network %q", net)
+	}
+	if version < 0 || version > 16 || len(program) < 2 || len(program) > 40 {
+		return "", fmt.Errorf("invalid witness program: version %v, %v bytes", version, len(program))
+	}
+	groups, _ := convert_bits(program, 8, 5, true)
+	data := append([]byte{byte(version)}, groups...)
+	constant := uint32(1)
+	if version > 0 {
+		constant = bech32m_const
+	}
+	values := append(bech32_hrp_expand(hrp), data...)
+	polymod := bech32_polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ constant
+	for i := 0; i < 6; i++ {
+		data = append(data, byte(polymod>>(5*(5-i))&31))
+	}
+	var sb strings.Builder
+	sb.WriteString(hrp + "1")
+	for _, d := range data {
+		sb.WriteByte(bech32_charset[d])
+	}
+	return sb.String(), nil
+}
+
+var segwit_hrp = map[string]string{
+	"main": "bc",
+	"test": "tb",
+}
+
+// b58decode is the inverse of b58encode.
+func b58decode(s string) ([]byte, error) {
+	alphabet := "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	n := new(big.Int)
+	for _, c := range s {
+		i := strings.IndexRune(alphabet, c)
+		if i < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+		n.Mul(n, big.NewInt(58))
+		n.Add(n, big.NewInt(int64(i)))
+	}
+	num_leading_zeros := len(s) - len(strings.TrimLeft(s, string(alphabet[0])))
+	return append(make([]byte, num_leading_zeros), n.Bytes()...), nil
+}
+
+// ParseAddress returns the scriptPubKey that pays to a base58 P2PKH or P2SH
+// address, or to a bech32/bech32m segwit address, of network net.
+func ParseAddress(net string, address string) (ByteScript, error) {
+	hrp, ok := segwit_hrp[net]
+	if !ok {
+		return ByteScript{}, fmt.Errorf("unknown network %q", net)
+	}
+	if strings.HasPrefix(strings.ToLower(address), hrp+"1") {
+		version, program, err := decode_segwit_address(hrp, address)
+		if err != nil {
+			return ByteScript{}, err
+		}
+		return witness_program(version, program), nil
+	}
+	b, err := b58decode(address)
+	if err != nil {
+		return ByteScript{}, err
+	}
+	if len(b) != 25 {
+		return ByteScript{}, fmt.Errorf("address %v decodes to %v bytes, not 25", address, len(b))
+	}
+	checksum := Hash256(b[:21])
+	if !bytes.Equal(checksum[:4], b[21:]) {
+		return ByteScript{}, fmt.Errorf("address %v has a bad checksum", address)
+	}
+	switch b[0] {
+	case p2pkh_version[net]:
+		return P2PKH(b[1:21]), nil
+	case p2sh_version[net]:
+		return P2SH(b[1:21]), nil
+	}
+	return ByteScript{}, fmt.Errorf("address %v is not a %v network address", address, net)
+}
+
+func decode_segwit_address(hrp string, address string) (int, []byte, error) {
+	if address != strings.ToLower(address) && address != strings.ToUpper(address) {
+		return 0, nil, fmt.Errorf("address %v mixes upper and lower case", address)
+	}
+	address = strings.ToLower(address)
+	if len(address) > 90 || len(address) < len(hrp)+8 {
+		return 0, nil, fmt.Errorf("address %v has an invalid length", address)
+	}
+	var data []byte
+	for _, c := range address[len(hrp)+1:] {
+		i := strings.IndexRune(bech32_charset, c)
+		if i < 0 {
+			return 0, nil, fmt.Errorf("invalid bech32 character %q", c)
+		}
+		data = append(data, byte(i))
+	}
+	version := int(data[0])
+	constant := uint32(1)
+	if version > 0 {
+		constant = bech32m_const
+	}
+	if bech32_polymod(append(bech32_hrp_expand(hrp), data...)) != constant {
+		return 0, nil, fmt.Errorf("address %v has a bad checksum", address)
+	}
+	program, err := convert_bits(data[1:len(data)-6], 5, 8, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	if version > 16 || len(program) < 2 || len(program) > 40 || (version == 0 && len(program) != 20 && len(program) != 32) {
+		return 0, nil, fmt.Errorf("address %v has an invalid witness program", address)
+	}
+	return version, program, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSegwitAddress(t *testing.T) {
+	// From BIP173 and BIP350.
+	program := sha256(unhex(t, "210279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798ac"))
+	if a, _ := SegwitAddress("test", 0, program); a != "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7" {
+		t.Errorf("P2WSH address %v", a)
+	}
+	if a, _ := SegwitAddress("main", 1, unhex(t, "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798")); a != "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0" {
+		t.Errorf("P2TR address %v", a)
+	}
+	if _, err := SegwitAddress("regtest", 0, program); err == nil {
+		t.Error("unknown network")
+	}
+	if _, err := SegwitAddress("main", 17, program); err == nil {
+		t.Error("witness version 17")
+	}
+}
+
+func TestScriptAddress(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	script := P2PK(PublicKey{*gen.G}.encode(true, false))
+	main, err := ScriptAddress("main", script)
+	if err != nil || main[0] != '3' {
+		t.Fatalf("mainnet P2SH address %v, %v", main, err)
+	}
+	test, err := ScriptAddress("test", script)
+	if err != nil || test[0] != '2' {
+		t.Fatalf("testnet P2SH address %v, %v", test, err)
+	}
+	if a, err := ScriptAddress("regtest", script); err == nil {
+		t.Fatalf("unknown network gave address %v", a)
+	}
+	got, err := ParseAddress("main", main)
+	if err != nil || !bytes.Equal(got.cmds, P2SH(Hash160(script.cmds)).cmds) {
+		t.Fatalf("ParseAddress(%v) = %x, %v", main, got.cmds, err)
+	}
+	if _, err := ParseAddress("test", main); err == nil {
+		t.Error("mainnet address parsed as testnet")
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	hash := PublicKey{*gen.G}.encode(true, true)
+	const p2pkh = "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH"
+	got, err := ParseAddress("main", p2pkh)
+	if err != nil || !bytes.Equal(got.cmds, P2PKH(hash).cmds) {
+		t.Fatalf("ParseAddress(%v) = %x, %v", p2pkh, got.cmds, err)
+	}
+	if a := (PublicKey{*gen.G}).address("main", true); a != p2pkh {
+		t.Error("PublicKey.address")
+	}
+	segwit, _ := SegwitAddress("test", 0, hash)
+	got, err = ParseAddress("test", segwit)
+	if err != nil || !bytes.Equal(got.cmds, P2WPKH(hash).cmds) {
+		t.Fatalf("ParseAddress(%v) = %x, %v", segwit, got.cmds, err)
+	}
+	bad := []string{
+		p2pkh[:len(p2pkh)-1] + "J", // bad checksum
+		p2pkh + "1",
+		"1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAM0", // 0 is not base58
+		segwit[:len(segwit)-1] + "q",
+		"tb1Qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", // mixed case
+	}
+	for _, a := range bad {
+		if _, err := ParseAddress("test", a); err == nil {
+			t.Errorf("parsed %v", a)
+		}
+	}
+	if _, err := ParseAddress("regtest", p2pkh); err == nil {
+		t.Error("unknown network")
+	}
+}
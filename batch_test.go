@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math/big"
+	"testing"
+)
+
+func TestBatchVerifier(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	b := NewBatchVerifier(gen)
+	if ok, bad := b.Verify(); !ok || bad != nil {
+		t.Fatal("empty batch failed")
+	}
+	for i := int64(1); i <= 6; i++ {
+		secret := big.NewInt(1000 + i)
+		message := Hash256([]byte{byte(i)})
+		sig, err := schnorr_sign(secret, gen, message[:], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public := gen.base_multiply(secret).x.Bytes()
+		if i == 4 {
+			message[0] ^= 1
+		}
+		b.AddSchnorr(public, message[:], sig)
+	}
+	ok, bad := b.Verify()
+	if ok || len(bad) != 1 || bad[0] != 3 {
+		t.Fatalf("batch with signature 3 bad: %v %v", ok, bad)
+	}
+}
+
+// Entries keep the order they were added in across both kinds.
+func TestBatchVerifierMixed(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	b := NewBatchVerifier(gen)
+	for i := int64(1); i <= 8; i++ {
+		secret := big.NewInt(2000 + i)
+		message := []byte{byte(i)}
+		var index int
+		if i%2 == 0 {
+			sig, err := schnorr_sign(secret, gen, message, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			index = b.AddSchnorr(gen.base_multiply(secret).x.Bytes(), message, sig)
+		} else {
+			index = b.AddECDSA(gen.base_multiply(secret), message, test_sign(secret, gen, message))
+		}
+		if index != int(i-1) {
+			t.Fatalf("signature %v added at index %v", i, index)
+		}
+	}
+	if ok, bad := b.Verify(); !ok {
+		t.Fatalf("good batch failed at %v", bad)
+	}
+	b.AddECDSA(gen.base_multiply(big.NewInt(7)), []byte{1}, test_sign(big.NewInt(8), gen, []byte{1}))
+	sig, _ := schnorr_sign(big.NewInt(9), gen, []byte{2}, nil)
+	b.AddSchnorr(gen.base_multiply(big.NewInt(9)).x.Bytes(), []byte{3}, sig)
+	ok, bad := b.Verify()
+	if ok || len(bad) != 2 || bad[0] != 8 || bad[1] != 9 || b.Len() != 10 {
+		t.Fatalf("batch with signatures 8 and 9 bad: %v %v", ok, bad)
+	}
+}
+
+type failing_reader struct{}
+
+func (failing_reader) Read([]byte) (int, error) {
+	return 0, errors.New("no randomness")
+}
+
+// Without randomness for the combined check, Schnorr signatures are checked
+// one by one rather than the verifier panicking.
+func TestBatchVerifierRandFailure(t *testing.T) {
+	defer func(r io.Reader) { batch_rand = r }(batch_rand)
+	batch_rand = failing_reader{}
+	gen, _ := LookupCurve("secp256k1")
+	b := NewBatchVerifier(gen)
+	for i := int64(1); i <= 3; i++ {
+		secret := big.NewInt(3000 + i)
+		message := []byte{byte(i)}
+		sig, err := schnorr_sign(secret, gen, message, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 3 {
+			message = []byte{0}
+		}
+		b.AddSchnorr(gen.base_multiply(secret).x.Bytes(), message, sig)
+	}
+	ok, bad := b.Verify()
+	if ok || len(bad) != 1 || bad[0] != 2 {
+		t.Fatalf("batch with signature 2 bad: %v %v", ok, bad)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+const header_size = 80
+
+// BlockHeader is what miners hash. prev_block and merkle_root are in
+// internal byte order, like every Hash.
+type BlockHeader struct {
+	version     uint32
+	prev_block  Hash
+	merkle_root Hash
+	timestamp   uint32
+	bits        uint32
+	nonce       uint32
+}
+
+type Block struct {
+	header BlockHeader
+	txs    []Tx
+}
+
+func (h BlockHeader) HeaderEncode() []byte {
+	out := make([]byte, 0, header_size)
+	out = append(out, u32_le(h.version)...)
+	out = append(out, h.prev_block[:]...)
+	out = append(out, h.merkle_root[:]...)
+	out = append(out, u32_le(h.timestamp)...)
+	out = append(out, u32_le(h.bits)...)
+	return append(out, u32_le(h.nonce)...)
+}
+
+// BlockHash is Hash256 of the header, the id block explorers show reversed.
+func (h BlockHeader) BlockHash() Hash {
+	return Hash256(h.HeaderEncode())
+}
+
+// BlockEncode serializes the block with its transactions' witnesses.
+func (b Block) BlockEncode() []byte {
+	out := b.header.HeaderEncode()
+	out = append(out, encode_varint(uint64(len(b.txs)))...)
+	for _, tx := range b.txs {
+		out = append(out, tx.TxEncode(-1)...)
+	}
+	return out
+}
+
+func (b Block) BlockHash() Hash {
+	return b.header.BlockHash()
+}
+
+func (r *reader) header() (h BlockHeader) {
+	h.version = r.u32()
+	h.prev_block = r.hash()
+	h.merkle_root = r.hash()
+	h.timestamp = r.u32()
+	h.bits = r.u32()
+	h.nonce = r.u32()
+	return h
+}
+
+func ParseBlockHeader(b []byte) (BlockHeader, error) {
+	r := &reader{b: b}
+	h := r.header()
+	return h, r.done()
+}
+
+// ParseBlock parses a serialized block, such as the hex getblock returns
+// at verbosity 0.
+func ParseBlock(b []byte) (Block, error) {
+	r := &reader{b: b}
+	block := Block{header: r.header()}
+	// The smallest transaction has one input and one output.
+	n := r.count(60)
+	for i := 0; i < n && r.err == nil; i++ {
+		block.txs = append(block.txs, r.tx(true))
+	}
+	return block, r.done()
+}
+
+func ParseBlockHex(s string) (Block, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return Block{}, err
+	}
+	return ParseBlock(b)
+}
+
+// The genesis blocks share their coinbase transaction and so their merkle
+// root. Only the timestamp, target and nonce differ.
+const genesis_coinbase_hex = "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+
+var genesis_params = map[string]struct{ timestamp, bits, nonce uint32 }{
+	"main":    {1231006505, 0x1d00ffff, 2083236893},
+	"test":    {1296688602, 0x1d00ffff, 414098458},
+	"regtest": {1296688602, 0x207fffff, 2},
+}
+
+// GenesisBlock returns the first block of net: "main", "test" (testnet3) or
+// "regtest".
+func GenesisBlock(net string) (Block, error) {
+	params, ok := genesis_params[net]
+	if !ok {
+		return Block{}, fmt.Errorf("unknown network %q", net)
+	}
+	b, _ := hex.DecodeString(genesis_coinbase_hex)
+	coinbase, err := ParseTx(b)
+	if err != nil {
+		return Block{}, err
+	}
+	header := BlockHeader{
+		version:     1,
+		merkle_root: coinbase.TxID(),
+		timestamp:   params.timestamp,
+		bits:        params.bits,
+		nonce:       params.nonce,
+	}
+	return Block{header: header, txs: []Tx{coinbase}}, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenesisBlocks(t *testing.T) {
+	hashes := map[string]string{
+		"main":    "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
+		"test":    "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943",
+		"regtest": "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206",
+	}
+	for net, hash := range hashes {
+		b, err := GenesisBlock(net)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := b.BlockHash().String(); got != hash {
+			t.Errorf("%v genesis block hash %v", net, got)
+		}
+		if got := b.txs[0].TxID().String(); got != "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b" {
+			t.Errorf("%v genesis coinbase txid %v", net, got)
+		}
+		parsed, err := ParseBlock(b.BlockEncode())
+		if err != nil || !bytes.Equal(parsed.BlockEncode(), b.BlockEncode()) {
+			t.Fatalf("%v: block round trip: %v", net, err)
+		}
+		header, err := ParseBlockHeader(b.header.HeaderEncode())
+		if err != nil || header != b.header {
+			t.Fatalf("%v: header round trip: %v", net, err)
+		}
+	}
+	if _, err := GenesisBlock("signet2"); err == nil {
+		t.Error("genesis block of an unknown network")
+	}
+}
+
+func TestParseBlockHeader(t *testing.T) {
+	raw, _ := hex.DecodeString("0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d1dac2b7c")
+	h, err := ParseBlockHeader(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.version != 1 || h.timestamp != 1231006505 || h.bits != 0x1d00ffff || h.nonce != 2083236893 {
+		t.Errorf("header fields %+v", h)
+	}
+	if !bytes.Equal(h.HeaderEncode(), raw) {
+		t.Error("header round trip")
+	}
+	if _, err := ParseBlockHeader(raw[:79]); err == nil {
+		t.Error("parsed a truncated header")
+	}
+	if _, err := ParseBlockHeader(append(raw, 0)); err == nil {
+		t.Error("parsed a header with trailing data")
+	}
+	genesis, _ := GenesisBlock("main")
+	encoded := genesis.BlockEncode()
+	if _, err := ParseBlock(encoded[:len(encoded)-1]); err == nil {
+		t.Error("parsed a truncated block")
+	}
+	if _, err := ParseBlockHex(hex.EncodeToString(encoded) + "00"); err == nil {
+		t.Error("parsed a block with trailing data")
+	}
+	if b, err := ParseBlockHex(hex.EncodeToString(encoded)); err != nil || b.BlockHash() != genesis.BlockHash() {
+		t.Errorf("ParseBlockHex: %v", err)
+	}
+}
+
+// TestMainnetBlock checks a real segwit-era mainnet block: its id, its
+// merkle root, its witness commitment and that it serializes back to the
+// same bytes.
+func TestMainnetBlock(t *testing.T) {
+	const hash = "0000000000000000001602407ac49862a7bca9d00f7f402db20b7be2f5de59d2"
+	raw, err := os.ReadFile(filepath.Join("testdata", "block-"+hash+".hex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ParseBlockHex(strings.TrimSpace(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.BlockHash().String(); got != hash {
+		t.Fatalf("block hash %v", got)
+	}
+	segwit := 0
+	for _, tx := range b.txs {
+		if tx.has_witness() {
+			segwit++
+		}
+	}
+	if len(b.txs) != 3315 || segwit != 1341 {
+		t.Fatalf("%v transactions, %v with witnesses", len(b.txs), segwit)
+	}
+	if got := b.MerkleRoot().String(); got != "7343589f88a866dee0247b29d1330467201e7eb9bb0001a01ac0922a983a9e52" || b.MerkleRoot() != b.header.merkle_root {
+		t.Errorf("merkle root %v", got)
+	}
+	commitment, found := b.witness_commitment()
+	if !found || hex.EncodeToString(commitment[:]) != "26402ed52f8eee7114e8f5c57c79a7862371c7f0dbfe51e7152e67d36b143593" {
+		t.Fatalf("witness commitment %x", commitment[:])
+	}
+	if got := WitnessCommitment(b.WitnessRoot(), b.txs[0].tx_ins[0].witness[0]); got != commitment {
+		t.Errorf("witness root commits to %x", got[:])
+	}
+	if err := b.CheckMerkleRoots(); err != nil {
+		t.Error(err)
+	}
+	encoded := hex.EncodeToString(b.BlockEncode())
+	if encoded != strings.TrimSpace(string(raw)) {
+		t.Error("block does not re-encode to the same bytes")
+	}
+
+	// Witnesses are outside the merkle root but inside the commitment.
+	changed := false
+	for _, tx := range b.txs[1:] {
+		for _, tx_in := range tx.tx_ins {
+			for _, item := range tx_in.witness {
+				if len(item) > 0 && !changed {
+					item[0] ^= 1
+					changed = true
+				}
+			}
+		}
+	}
+	if b.MerkleRoot() != b.header.merkle_root || b.CheckMerkleRoots() == nil {
+		t.Error("changing a witness should only break the witness commitment")
+	}
+}
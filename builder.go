@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// dust_relay_fee is the feerate, in sat/vB, at which an output is dust if
+// spending it costs more than it is worth.
+const dust_relay_fee = 3
+
+// dust_threshold returns the smallest amount an output paying to
+// script_pubkey may have, as Bitcoin Core computes it: the output's size plus
+// that of a typical input spending it, at the dust relay fee. That is 546
+// sat for P2PKH and 294 for P2WPKH. OP_RETURN outputs are never dust.
+func dust_threshold(script_pubkey Script) int64 {
+	body := script_body(script_pubkey)
+	if len(body) > 0 && body[0] == OP_RETURN {
+		return 0
+	}
+	size := len(TxOut{script_pubkey: script_pubkey}.txout_encode())
+	if _, _, ok := parse_witness_program(body); ok {
+		size += 32 + 4 + 1 + 107/4 + 4
+	} else {
+		size += 32 + 4 + 1 + 107 + 4
+	}
+	return int64(size * dust_relay_fee)
+}
+
+type builder_utxo struct {
+	prev_tx       Hash
+	prev_index    int
+	script_pubkey ByteScript
+	amount        int64
+	secret_key    *big.Int
+	pubkey        []byte // compressed public key of secret_key
+	redeem_script Script // set for P2SH-P2WPKH
+}
+
+// TxBuilder assembles and signs a transaction from the outputs it spends,
+// the recipients it pays and a feerate. It spends every UTXO added to it and
+// sends what is left after the fee to a change output, unless that would be
+// dust, in which case it goes to the miner.
+type TxBuilder struct {
+	gen     Generator
+	net     string
+	utxos   []builder_utxo
+	outs    []TxOut
+	change  Script
+	feerate float64
+	rbf     bool
+}
+
+func NewTxBuilder(gen Generator, net string) *TxBuilder {
+	return &TxBuilder{gen: gen, net: net, feerate: 1}
+}
+
+// AddUTXO adds an output to spend, with the key that can sign for it. P2PKH,
+// P2WPKH and P2SH-P2WPKH outputs of the key are supported.
+func (b *TxBuilder) AddUTXO(prev_tx Hash, prev_index int, script_pubkey Script, amount int64, secret_key *big.Int) error {
+	pubkey := PublicKey{b.gen.base_multiply(secret_key)}.encode(true, false)
+	u := builder_utxo{
+		prev_tx:       prev_tx,
+		prev_index:    prev_index,
+		script_pubkey: ByteScript{cmds: script_body(script_pubkey)},
+		amount:        amount,
+		secret_key:    secret_key,
+		pubkey:        pubkey,
+	}
+	tmpl := Classify(u.script_pubkey)
+	pubkey_hash := Hash160(pubkey)
+	switch {
+	case (tmpl.Class == PubKeyHashClass || tmpl.Class == WitnessPubKeyHashClass) && bytes.Equal(tmpl.Hash, pubkey_hash):
+	case tmpl.Class == ScriptHashClass && bytes.Equal(tmpl.Hash, Hash160(P2WPKH(pubkey_hash).cmds)):
+		u.redeem_script = P2WPKH(pubkey_hash)
+	default:
+		return fmt.Errorf("cannot spend a %v output with key %x", tmpl.Class, pubkey)
+	}
+	if amount <= 0 {
+		return fmt.Errorf("UTXO amount must be positive, got %v", amount)
+	}
+	b.utxos = append(b.utxos, u)
+	return nil
+}
+
+// AddRecipient pays amount satoshis to address.
+func (b *TxBuilder) AddRecipient(address string, amount int64) error {
+	script_pubkey, err := ParseAddress(b.net, address)
+	if err != nil {
+		return err
+	}
+	if dust := dust_threshold(script_pubkey); amount < dust {
+		return fmt.Errorf("paying %v to %v is below the dust limit of %v", amount, address, dust)
+	}
+	b.outs = append(b.outs, TxOut{amount: amount, script_pubkey: script_pubkey})
+	return nil
+}
+
+// SetChangeAddress sets where what is left over goes. Without one, it all
+// goes to the fee.
+func (b *TxBuilder) SetChangeAddress(address string) error {
+	script_pubkey, err := ParseAddress(b.net, address)
+	if err != nil {
+		return err
+	}
+	b.change = script_pubkey
+	return nil
+}
+
+// SetFeeRate sets the feerate in sat/vB.
+func (b *TxBuilder) SetFeeRate(feerate float64) {
+	b.feerate = feerate
+}
+
+// EnableRBF makes the transaction signal BIP125 replaceability.
+func (b *TxBuilder) EnableRBF() {
+	b.rbf = true
+}
+
+// Build returns the signed transaction.
+func (b *TxBuilder) Build() (Tx, error) {
+	if len(b.utxos) == 0 {
+		return Tx{}, fmt.Errorf("no UTXOs to spend")
+	}
+	if len(b.outs) == 0 && b.change == nil {
+		return Tx{}, fmt.Errorf("no recipients")
+	}
+	tx := Tx{version: 2}
+	var in_total, out_total int64
+	for _, u := range b.utxos {
+		tx_in := NewTxIn(u.prev_tx, u.prev_index)
+		tx_in.prev_tx_script_pubkey = u.script_pubkey
+		tx_in.prev_amount = u.amount
+		tx_in.redeem_script = u.redeem_script
+		tx.tx_ins = append(tx.tx_ins, tx_in)
+		in_total += u.amount
+	}
+	if b.rbf {
+		tx.SignalRBF()
+	}
+	tx.tx_outs = append([]TxOut(nil), b.outs...)
+	for _, out := range b.outs {
+		out_total += out.amount
+	}
+	if in_total < out_total {
+		return Tx{}, fmt.Errorf("UTXOs hold %v, recipients need %v", in_total, out_total)
+	}
+	want := func(vsize int) int64 {
+		return int64(math.Ceil(b.feerate * float64(vsize)))
+	}
+	if b.change != nil {
+		tx.tx_outs = append(tx.tx_outs, TxOut{amount: in_total - out_total, script_pubkey: b.change})
+		with_change := tx
+		with_change.tx_outs = append([]TxOut(nil), tx.tx_outs...)
+		err := pay_fee_from(&with_change, len(tx.tx_outs)-1, want, b.sign)
+		if err == nil {
+			return with_change, nil
+		}
+		if _, dust := err.(dust_change_error); !dust {
+			return Tx{}, err
+		}
+		// The change would be dust, leave it out.
+		tx.tx_outs = tx.tx_outs[:len(tx.tx_outs)-1]
+	}
+	if err := b.sign(&tx); err != nil {
+		return Tx{}, err
+	}
+	if fee, need := in_total-out_total, want(tx.VSize()); fee < need {
+		return Tx{}, fmt.Errorf("UTXOs hold %v, recipients and fee need %v", in_total, out_total+need)
+	}
+	return tx, nil
+}
+
+// sign signs every input of tx, which spends b.utxos in order.
+func (b *TxBuilder) sign(tx *Tx) error {
+	for i := range tx.tx_ins {
+		u := b.utxos[i]
+		message, err := tx.SigMessage(i)
+		if err != nil {
+			return err
+		}
+		signature, err := ecdsa_sign(u.secret_key, b.gen, message)
+		if err != nil {
+			return err
+		}
+		sig := append(signature.sig_encode(), SIGHASH_ALL)
+		tx_in := &tx.tx_ins[i]
+		switch {
+		case Classify(u.script_pubkey).Class == PubKeyHashClass:
+			tx_in.script_sig = ByteScript{cmds: append(push_data(sig), push_data(u.pubkey)...)}
+		case u.redeem_script != nil:
+			tx_in.script_sig = ByteScript{cmds: push_data(script_body(u.redeem_script))}
+			tx_in.witness = [][]byte{sig, u.pubkey}
+		default:
+			tx_in.witness = [][]byte{sig, u.pubkey}
+		}
+	}
+	return nil
+}
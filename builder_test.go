@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestDustThreshold(t *testing.T) {
+	if d := dust_threshold(P2PKH(make([]byte, 20))); d != 546 {
+		t.Errorf("P2PKH dust %v", d)
+	}
+	if d := dust_threshold(P2WPKH(make([]byte, 20))); d != 294 {
+		t.Errorf("P2WPKH dust %v", d)
+	}
+	if d := dust_threshold(OpReturn([]byte("x"))); d != 0 {
+		t.Errorf("OP_RETURN dust %v", d)
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	key := big.NewInt(777)
+	pub := PublicKey{gen.base_multiply(key)}
+	pubkey_hash := pub.encode(true, true)
+	legacy := pub.address("test", true)
+	segwit, _ := SegwitAddress("test", 0, pubkey_hash)
+	const recipient = "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7"
+
+	b := NewTxBuilder(gen, "test")
+	var h Hash
+	for i, spk := range []ByteScript{P2PKH(pubkey_hash), P2WPKH(pubkey_hash), P2SH(Hash160(P2WPKH(pubkey_hash).cmds))} {
+		h[0] = byte(i)
+		if err := b.AddUTXO(h, i, spk, 20000, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.AddUTXO(h, 0, P2PKH(make([]byte, 20)), 1, key); err == nil {
+		t.Fatal("added a UTXO the key cannot spend")
+	}
+	if err := b.AddRecipient(segwit, 100); err == nil {
+		t.Fatal("added a dust output")
+	}
+	if err := b.AddRecipient(recipient, 30000); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetChangeAddress(segwit); err != nil {
+		t.Fatal(err)
+	}
+	b.SetFeeRate(12.5)
+	b.EnableRBF()
+	tx, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range tx.tx_ins {
+		if err := tx.VerifyInput(i, gen); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	if rate, _ := tx.FeeRate(); len(tx.tx_outs) != 2 || rate < 12.5 || rate > 13 || !tx.SignalsRBF() {
+		t.Fatalf("%v outputs at %v sat/vB", len(tx.tx_outs), rate)
+	}
+
+	// Change that would be dust goes to the fee.
+	b.outs = nil
+	b.AddRecipient(legacy, 60000-4550)
+	tx, err = b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.tx_outs) != 1 {
+		t.Fatalf("%v outputs, want the change left out", len(tx.tx_outs))
+	}
+	b.outs = nil
+	b.AddRecipient(legacy, 60000-1000)
+	if _, err = b.Build(); err == nil {
+		t.Fatal("built a transaction that cannot pay its fee")
+	}
+
+	// Errors other than dust change are returned, not taken to mean the
+	// change should be dropped.
+	b = NewTxBuilder(gen, "test")
+	bad_key := new(big.Int).Add(gen.n, big.NewInt(1))
+	g_hash := PublicKey{*gen.G}.encode(true, true)
+	if err := b.AddUTXO(h, 0, P2WPKH(g_hash), 20000, bad_key); err != nil {
+		t.Fatal(err)
+	}
+	b.AddRecipient(recipient, 10000)
+	b.SetChangeAddress(segwit)
+	if _, err := b.Build(); err == nil || !strings.Contains(err.Error(), "secret key") {
+		t.Fatalf("Build with an invalid key: %v", err)
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// UTXO is an unspent output as coin selection sees it. InputVSize is the
+// size in vbytes of the input that will spend it, once signed.
+type UTXO struct {
+	TxID         Hash
+	Index        int
+	ScriptPubKey Script
+	Amount       int64
+	InputVSize   int
+}
+
+// Sizes in vbytes used by coin selection: the parts of a segwit transaction
+// that are there whatever the inputs (version, counts, locktime, marker and
+// flag), a P2WPKH change output, and the input that later spends it.
+const (
+	tx_overhead_vsize  = 11
+	change_output_size = 31
+	change_spend_vsize = 68
+)
+
+// long_term_feerate, in sat/vB, is what spending an input is expected to
+// cost on average. Selections spending more inputs when fees are below it
+// are cheaper in the long run, and the waste metric says by how much.
+const long_term_feerate = 10
+
+// NewUTXO returns a UTXO, with the input size filled in as SpendFor guesses
+// it. Other outputs, multisig ones for instance, need InputVSize set by hand.
+func NewUTXO(txid Hash, index int, script_pubkey Script, amount int64) UTXO {
+	u := UTXO{TxID: txid, Index: index, ScriptPubKey: script_pubkey, Amount: amount}
+	if spend, err := SpendFor(script_pubkey); err == nil {
+		u.InputVSize, _ = spend.VSize()
+	}
+	return u
+}
+
+// effective_value is what the output adds to the transaction after paying
+// for its own input.
+func (u UTXO) effective_value(feerate float64) int64 {
+	return u.Amount - fee_for(u.InputVSize, feerate)
+}
+
+func fee_for(vsize int, feerate float64) int64 {
+	return int64(math.Ceil(feerate * float64(vsize)))
+}
+
+// Selection is the outcome of coin selection. SelectionFee is the part of
+// the fee coin selection is responsible for: that for the inputs, the fixed
+// overhead and the change output if there is one, plus, for changeless
+// selections, the excess given up to the fee. It leaves out the fee for the
+// recipients' outputs, which coin selection knows nothing about; a caller
+// that added that fee to the target pays SelectionFee on top of it.
+// Change is 0 for changeless selections. Waste is the metric Bitcoin Core
+// compares selections with: the extra cost of spending the inputs now
+// rather than at the long term feerate, plus either the cost of creating
+// and later spending the change or the excess given up to the fee.
+type Selection struct {
+	Inputs       []UTXO
+	SelectionFee int64
+	Change       int64
+	Waste        int64
+}
+
+// CoinSelector picks UTXOs to pay target satoshis at feerate sat/vB. The
+// target is the sum of the recipients' outputs; the fee for their size can
+// be added to it by the caller.
+type CoinSelector interface {
+	Select(utxos []UTXO, target int64, feerate float64) (Selection, error)
+}
+
+// cost_of_change is what adding a change output costs now plus what
+// spending it costs later.
+func cost_of_change(feerate float64) int64 {
+	return fee_for(change_output_size, feerate) + fee_for(change_spend_vsize, long_term_feerate)
+}
+
+// min_change is the smallest change output worth making: the P2WPKH dust limit.
+var min_change = dust_threshold(P2WPKH(make([]byte, 20)))
+
+// make_selection works out fee, change and waste of spending inputs. With
+// changeless, any excess goes to the fee.
+func make_selection(inputs []UTXO, target int64, feerate float64, changeless bool) (Selection, error) {
+	var in_total int64
+	vsize := tx_overhead_vsize
+	var waste int64
+	for _, u := range inputs {
+		in_total += u.Amount
+		vsize += u.InputVSize
+		waste += fee_for(u.InputVSize, feerate) - fee_for(u.InputVSize, long_term_feerate)
+	}
+	fee := fee_for(vsize, feerate)
+	excess := in_total - target - fee
+	if excess < 0 {
+		return Selection{}, fmt.Errorf("inputs hold %v, need %v", in_total, target+fee)
+	}
+	s := Selection{Inputs: inputs}
+	change := excess - fee_for(change_output_size, feerate)
+	if !changeless && change >= min_change {
+		s.SelectionFee = fee + fee_for(change_output_size, feerate)
+		s.Change = change
+		s.Waste = waste + cost_of_change(feerate)
+	} else {
+		s.SelectionFee = fee + excess
+		s.Waste = waste + excess
+	}
+	return s, nil
+}
+
+// usable returns the UTXOs worth spending at feerate, largest effective
+// value first, and the target their effective values must reach.
+func usable(utxos []UTXO, target int64, feerate float64) ([]UTXO, int64, error) {
+	var out []UTXO
+	for _, u := range utxos {
+		if u.InputVSize <= 0 {
+			return nil, 0, fmt.Errorf("UTXO %s:%v has no input size", u.TxID, u.Index)
+		}
+		if u.effective_value(feerate) > 0 {
+			out = append(out, u)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].effective_value(feerate) > out[j].effective_value(feerate)
+	})
+	return out, target + fee_for(tx_overhead_vsize, feerate), nil
+}
+
+func insufficient_funds(utxos []UTXO, target int64) error {
+	var total int64
+	for _, u := range utxos {
+		total += u.Amount
+	}
+	return fmt.Errorf("insufficient funds: UTXOs hold %v, need more than %v", total, target)
+}
+
+// BranchAndBound searches for a changeless selection: one whose effective
+// value lands between the target and the target plus the cost of change,
+// so dropping the change is cheaper than making it. Among those found it
+// keeps the least wasteful. It fails if there is none.
+type BranchAndBound struct {
+	// MaxTries bounds the search; 0 means 100000.
+	MaxTries int
+}
+
+func (bnb BranchAndBound) Select(utxos []UTXO, target int64, feerate float64) (Selection, error) {
+	pool, target_ev, err := usable(utxos, target, feerate)
+	if err != nil {
+		return Selection{}, err
+	}
+	max_tries := bnb.MaxTries
+	if max_tries == 0 {
+		max_tries = 100000
+	}
+	upper := target_ev + cost_of_change(feerate)
+	values := make([]int64, len(pool))
+	// remaining[i] is the effective value of pool[i:].
+	remaining := make([]int64, len(pool)+1)
+	for i := len(pool) - 1; i >= 0; i-- {
+		values[i] = pool[i].effective_value(feerate)
+		remaining[i] = remaining[i+1] + values[i]
+	}
+	if remaining[0] < target_ev {
+		return Selection{}, insufficient_funds(utxos, target)
+	}
+	var best []int
+	var best_waste int64 = math.MaxInt64
+	var picked []int
+	var value int64
+	tries := 0
+	// Depth first: at each UTXO first try including it, then leaving it out.
+	var search func(i int)
+	search = func(i int) {
+		if tries >= max_tries {
+			return
+		}
+		tries++
+		if value > upper || value+remaining[i] < target_ev {
+			return
+		}
+		if value >= target_ev {
+			var waste int64
+			for _, j := range picked {
+				waste += fee_for(pool[j].InputVSize, feerate) - fee_for(pool[j].InputVSize, long_term_feerate)
+			}
+			waste += value - target_ev
+			if waste < best_waste {
+				best_waste = waste
+				best = append([]int(nil), picked...)
+			}
+			return
+		}
+		if i == len(pool) {
+			return
+		}
+		// Including a UTXO equal to the one just left out finds nothing new.
+		skipped_equal := i > 0 && values[i] == values[i-1] && (len(picked) == 0 || picked[len(picked)-1] != i-1)
+		if !skipped_equal {
+			picked = append(picked, i)
+			value += values[i]
+			search(i + 1)
+			picked = picked[:len(picked)-1]
+			value -= values[i]
+		}
+		search(i + 1)
+	}
+	search(0)
+	if best == nil {
+		return Selection{}, fmt.Errorf("branch and bound found no changeless selection")
+	}
+	inputs := make([]UTXO, len(best))
+	for k, j := range best {
+		inputs[k] = pool[j]
+	}
+	return make_selection(inputs, target, feerate, true)
+}
+
+// LargestFirst spends the UTXOs with the largest effective value until the
+// target and a change output are paid for.
+type LargestFirst struct{}
+
+func (LargestFirst) Select(utxos []UTXO, target int64, feerate float64) (Selection, error) {
+	pool, target_ev, err := usable(utxos, target, feerate)
+	if err != nil {
+		return Selection{}, err
+	}
+	return accumulate(pool, target_ev, utxos, target, feerate)
+}
+
+// accumulate spends pool in order until its effective value covers
+// target_ev plus a change output, or runs out.
+func accumulate(pool []UTXO, target_ev int64, utxos []UTXO, target int64, feerate float64) (Selection, error) {
+	var value int64
+	for i, u := range pool {
+		value += u.effective_value(feerate)
+		if value >= target_ev+fee_for(change_output_size, feerate)+min_change {
+			return make_selection(pool[:i+1], target, feerate, false)
+		}
+	}
+	if value >= target_ev {
+		// Not enough for a change output, but enough without one.
+		return make_selection(pool, target, feerate, true)
+	}
+	return Selection{}, insufficient_funds(utxos, target)
+}
+
+// SingleRandomDraw spends UTXOs in random order until the target and a
+// change output are paid for. Rand may be set for reproducible draws.
+type SingleRandomDraw struct {
+	Rand *rand.Rand
+}
+
+func (srd SingleRandomDraw) Select(utxos []UTXO, target int64, feerate float64) (Selection, error) {
+	pool, target_ev, err := usable(utxos, target, feerate)
+	if err != nil {
+		return Selection{}, err
+	}
+	shuffle := rand.Shuffle
+	if srd.Rand != nil {
+		shuffle = srd.Rand.Shuffle
+	}
+	shuffle(len(pool), func(i, j int) {
+		pool[i], pool[j] = pool[j], pool[i]
+	})
+	return accumulate(pool, target_ev, utxos, target, feerate)
+}
+
+// Knapsack is Bitcoin Core's original selection: a UTXO matching the target
+// exactly if there is one, otherwise the best of many random subsets of the
+// UTXOs smaller than the target plus change, or the smallest larger UTXO if
+// that comes closer. Rand may be set for reproducible draws.
+type Knapsack struct {
+	Rand *rand.Rand
+	// Iterations of the random subset search; 0 means 1000.
+	Iterations int
+}
+
+func (k Knapsack) Select(utxos []UTXO, target int64, feerate float64) (Selection, error) {
+	pool, target_ev, err := usable(utxos, target, feerate)
+	if err != nil {
+		return Selection{}, err
+	}
+	r := k.Rand
+	if r == nil {
+		r = rand.New(rand.NewSource(rand.Int63()))
+	}
+	iterations := k.Iterations
+	if iterations == 0 {
+		iterations = 1000
+	}
+	with_change := target_ev + fee_for(change_output_size, feerate) + min_change
+	var smaller []UTXO
+	var smaller_total int64
+	var lowest_larger *UTXO
+	for i := range pool {
+		u := pool[i]
+		ev := u.effective_value(feerate)
+		switch {
+		case ev == target_ev:
+			return make_selection([]UTXO{u}, target, feerate, true)
+		case ev < with_change:
+			smaller = append(smaller, u)
+			smaller_total += ev
+		case lowest_larger == nil || ev < lowest_larger.effective_value(feerate):
+			lowest_larger = &pool[i]
+		}
+	}
+	if smaller_total == target_ev {
+		return make_selection(smaller, target, feerate, true)
+	}
+	if smaller_total < target_ev {
+		if lowest_larger == nil {
+			return Selection{}, insufficient_funds(utxos, target)
+		}
+		return make_selection([]UTXO{*lowest_larger}, target, feerate, false)
+	}
+	goal := with_change
+	if smaller_total < goal {
+		goal = target_ev
+	}
+	picked, best := approximate_best_subset(smaller, goal, feerate, r, iterations)
+	if lowest_larger != nil && ((best != target_ev && best < with_change) || lowest_larger.effective_value(feerate) <= best) {
+		return make_selection([]UTXO{*lowest_larger}, target, feerate, false)
+	}
+	var inputs []UTXO
+	for i, in := range picked {
+		if in {
+			inputs = append(inputs, smaller[i])
+		}
+	}
+	return make_selection(inputs, target, feerate, false)
+}
+
+// approximate_best_subset tries random subsets of utxos, keeping the one
+// with the smallest effective value that still reaches goal. Each pass goes
+// over the UTXOs twice: first including each with probability one half,
+// then including all the ones left out until the goal is reached.
+func approximate_best_subset(utxos []UTXO, goal int64, feerate float64, r *rand.Rand, iterations int) ([]bool, int64) {
+	best := make([]bool, len(utxos))
+	var best_value int64
+	for i, u := range utxos {
+		best[i] = true
+		best_value += u.effective_value(feerate)
+	}
+	included := make([]bool, len(utxos))
+	for rep := 0; rep < iterations && best_value != goal; rep++ {
+		for i := range included {
+			included[i] = false
+		}
+		var value int64
+		reached := false
+		for pass := 0; pass < 2 && !reached; pass++ {
+			for i, u := range utxos {
+				if included[i] || (pass == 0 && r.Intn(2) == 0) {
+					continue
+				}
+				value += u.effective_value(feerate)
+				included[i] = true
+				if value >= goal {
+					reached = true
+					if value < best_value {
+						best_value = value
+						copy(best, included)
+					}
+					value -= u.effective_value(feerate)
+					included[i] = false
+				}
+			}
+		}
+	}
+	return best, best_value
+}
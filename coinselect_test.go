@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func test_utxos(r *rand.Rand, n int) []UTXO {
+	var utxos []UTXO
+	var h Hash
+	for i := 0; i < n; i++ {
+		h[0] = byte(i)
+		spk := P2WPKH(make([]byte, 20))
+		if i%3 == 0 {
+			spk = P2PKH(make([]byte, 20))
+		}
+		utxos = append(utxos, NewUTXO(h, i, spk, int64(1000+r.Intn(200000))))
+	}
+	return utxos
+}
+
+func TestCoinSelectors(t *testing.T) {
+	utxos := test_utxos(rand.New(rand.NewSource(1)), 40)
+	selectors := map[string]CoinSelector{
+		"branch and bound":   BranchAndBound{},
+		"knapsack":           Knapsack{Rand: rand.New(rand.NewSource(2))},
+		"largest first":      LargestFirst{},
+		"single random draw": SingleRandomDraw{Rand: rand.New(rand.NewSource(3))},
+	}
+	const feerate = 7
+	for _, target := range []int64{5000, 150000, 523456, 1500000} {
+		for name, s := range selectors {
+			sel, err := s.Select(utxos, target, feerate)
+			if err != nil {
+				if name == "branch and bound" {
+					continue // there may be no changeless selection
+				}
+				t.Fatalf("%v, target %v: %v", name, target, err)
+			}
+			var in_total int64
+			vsize := tx_overhead_vsize
+			for _, u := range sel.Inputs {
+				in_total += u.Amount
+				vsize += u.InputVSize
+			}
+			if sel.Change > 0 {
+				vsize += change_output_size
+				if sel.Change < min_change {
+					t.Errorf("%v, target %v: dust change %v", name, target, sel.Change)
+				}
+			}
+			if in_total != target+sel.SelectionFee+sel.Change {
+				t.Errorf("%v, target %v: inputs %v, fee %v, change %v", name, target, in_total, sel.SelectionFee, sel.Change)
+			}
+			if sel.SelectionFee < fee_for(vsize, feerate) {
+				t.Errorf("%v, target %v: fee %v for %v vbytes", name, target, sel.SelectionFee, vsize)
+			}
+		}
+	}
+	if _, err := (LargestFirst{}).Select(utxos, 1e9, 5); err == nil {
+		t.Fatal("selected more than the UTXOs hold")
+	}
+	if _, err := (LargestFirst{}).Select([]UTXO{{Amount: 1000}}, 100, 5); err == nil {
+		t.Fatal("selected a UTXO without an input size")
+	}
+}
+
+// TestBranchAndBoundExact gives branch and bound two UTXOs that pay a 1
+// sat/vB target exactly.
+func TestBranchAndBoundExact(t *testing.T) {
+	var h Hash
+	p2wpkh := P2WPKH(make([]byte, 20))
+	utxos := []UTXO{NewUTXO(h, 0, p2wpkh, 10000+68), NewUTXO(h, 1, p2wpkh, 20000+68), NewUTXO(h, 2, p2wpkh, 50000)}
+	sel, err := BranchAndBound{}.Select(utxos, 30000-tx_overhead_vsize, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sel.Inputs) != 2 || sel.Change != 0 {
+		t.Fatalf("selection %+v", sel)
+	}
+	// Spending at 1 sat/vB saves 9 sat/vB on each input.
+	if want := int64(-2 * 9 * 68); sel.Waste != want {
+		t.Errorf("waste %v, want %v", sel.Waste, want)
+	}
+	if _, err := (BranchAndBound{}).Select(utxos[2:], 30000, 1); err == nil {
+		t.Error("found a changeless selection where there is none")
+	}
+}
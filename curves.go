@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// named_curves maps a curve name to its generator, which in turn carries the
+// curve itself and the order of the generator.
+var named_curves = map[string]Generator{}
+
+func hex_int(s string) *big.Int {
+	i, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("invalid hex integer " + s)
+	}
+	return i
+}
+
+func register_curve(name, p, a, b, gx, gy, n string) {
+	curve := NewCurve(hex_int(p), hex_int(a), hex_int(b))
+	G, err := NewPoint(curve, hex_int(gx), hex_int(gy))
+	if err != nil {
+		panic(fmt.Sprintf("generator of %v: %v", name, err))
+	}
+	named_curves[name] = NewGenerator(&G, hex_int(n))
+}
+
+func init() {
+	// The curve Bitcoin uses
+	register_curve("secp256k1",
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F",
+		"0",
+		"7",
+		"79BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798",
+		"483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8",
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141")
+	// NIST P-256, which has a = -3
+	register_curve("secp256r1",
+		"ffffffff00000001000000000000000000000000ffffffffffffffffffffffff",
+		"ffffffff00000001000000000000000000000000fffffffffffffffffffffffc",
+		"5ac635d8aa3a93e7b3ebbd55769886bc651d06b0cc53b0f63bce3c3e27d2604b",
+		"6b17d1f2e12c4247f8bce6e563a440f277037d812deb33a0f4a13945d898c296",
+		"4fe342e2fe1a7f9b8ee7eb4a7c0f9e162bce33576b315ececbb6406837bf51f5",
+		"ffffffff00000000ffffffffffffffffbce6faada7179e84f3b9cac2fc632551")
+	// Toy curves small enough to enumerate by hand. Both have a prime number
+	// of points, so every point other than infinity generates the whole group.
+	// y^2 = x^3 + 7 mod 43, the same equation as secp256k1, with 31 points
+	register_curve("toy43", "2b", "0", "7", "2", "c", "1f")
+	// y^2 = x^3 - 3x + 5 mod 71, with 83 points
+	register_curve("toy71", "47", "44", "5", "0", "11", "53")
+}
+
+// LookupCurve returns the generator of a named curve.
+func LookupCurve(name string) (Generator, bool) {
+	gen, ok := named_curves[name]
+	return gen, ok
+}
+
+func CurveNames() []string {
+	names := make([]string, 0, len(named_curves))
+	for name := range named_curves {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
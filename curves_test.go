@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+// TestToyCurveOrder counts the points of the toy curves by brute force and
+// checks that the registered order matches and that n*G is infinity.
+func TestToyCurveOrder(t *testing.T) {
+	for _, name := range []string{"toy43", "toy71"} {
+		gen, _ := LookupCurve(name)
+		curve := gen.G.curve
+		count := int64(1) // infinity
+		for x := int64(0); x < curve.p.Int64(); x++ {
+			for y := int64(0); y < curve.p.Int64(); y++ {
+				if _, err := NewPoint(curve, big.NewInt(x), big.NewInt(y)); err == nil {
+					count++
+				}
+			}
+		}
+		if count != gen.n.Int64() {
+			t.Errorf("%v has %v points, registered order %v", name, count, gen.n)
+		}
+	}
+}
+
+func TestCurveRegistry(t *testing.T) {
+	for _, name := range CurveNames() {
+		gen, ok := LookupCurve(name)
+		if !ok {
+			t.Fatalf("%v is listed but not registered", name)
+		}
+		if !gen.G.verify_on_curve(&gen.G.curve) {
+			t.Errorf("%v: generator is not on the curve", name)
+		}
+		nG, _ := gen.G.double_and_add(gen.n)
+		if !nG.IsInfinity() {
+			t.Errorf("%v: n*G is not infinity", name)
+		}
+		n1 := new(big.Int).Sub(gen.n, big.NewInt(1))
+		if minus, _ := gen.G.double_and_add(n1); !minus.Compare(Point{curve: gen.G.curve, x: gen.G.x, y: gen.G.y.Neg()}) {
+			t.Errorf("%v: (n-1)*G is not -G", name)
+		}
+	}
+	if _, ok := LookupCurve("secp256k2"); ok {
+		t.Error("found an unknown curve")
+	}
+}
+
+// TestP256 checks that the a coefficient is used: P-256 has a = -3, and
+// doubling with a = 0 would give a point off the curve.
+func TestP256(t *testing.T) {
+	gen, _ := LookupCurve("secp256r1")
+	a := new(big.Int).Sub(gen.G.curve.p, big.NewInt(3))
+	if gen.G.curve.a.Big().Cmp(a) != 0 {
+		t.Fatal("a is not -3")
+	}
+	twoG := gen.G.elliptic_curve_addition(*gen.G)
+	want := hex_int("7CF27B188D034F7E8A52380304B51AC3C08969E277F21B35A60B48FC47669978")
+	if twoG.x.Big().Cmp(want) != 0 {
+		t.Errorf("2G.x = %x", twoG.x.Big())
+	}
+	if !twoG.verify_on_curve(&gen.G.curve) {
+		t.Error("2G is not on the curve")
+	}
+	k1, _ := LookupCurve("secp256k1")
+	if gen.G.curve.Equal(k1.G.curve) {
+		t.Error("P-256 equals secp256k1")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// reader reads the wire encodings back. The first error sticks: later reads
+// return zero values, so a sequence of reads only needs checking once.
+type reader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *reader) read(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	if n < 0 || n > len(r.b)-r.off {
+		r.err = fmt.Errorf("unexpected end of data: need %v bytes at offset %v, have %v", n, r.off, len(r.b)-r.off)
+		return make([]byte, n)
+	}
+	out := r.b[r.off : r.off+n]
+	r.off += n
+	return out
+}
+
+func (r *reader) u8() byte {
+	return r.read(1)[0]
+}
+
+func (r *reader) u16() uint16 {
+	return binary.LittleEndian.Uint16(r.read(2))
+}
+
+func (r *reader) u32() uint32 {
+	return binary.LittleEndian.Uint32(r.read(4))
+}
+
+func (r *reader) u64() uint64 {
+	return binary.LittleEndian.Uint64(r.read(8))
+}
+
+func (r *reader) hash() (h Hash) {
+	copy(h[:], r.read(32))
+	return h
+}
+
+func (r *reader) varint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	n, size, err := decode_varint(r.b[r.off:])
+	if err != nil {
+		r.err = err
+		return 0
+	}
+	r.off += size
+	return n
+}
+
+// count reads a varint that counts items of at least min_size bytes each,
+// refusing counts the remaining data could not hold.
+func (r *reader) count(min_size int) int {
+	n := r.varint()
+	if r.err == nil && n > uint64(len(r.b)-r.off)/uint64(min_size) {
+		r.err = fmt.Errorf("count %v at offset %v is larger than the data", n, r.off)
+		return 0
+	}
+	return int(n)
+}
+
+// var_bytes reads a varint length followed by that many bytes.
+func (r *reader) var_bytes() []byte {
+	return r.read(r.count(1))
+}
+
+// done reports the first error, or an error if data is left over.
+func (r *reader) done() error {
+	if r.err == nil && r.off != len(r.b) {
+		return fmt.Errorf("%v bytes left over", len(r.b)-r.off)
+	}
+	return r.err
+}
+
+// ParseTx parses a serialized transaction, in the legacy or BIP144 format.
+func ParseTx(b []byte) (Tx, error) {
+	r := &reader{b: b}
+	tx := r.tx(true)
+	return tx, r.done()
+}
+
+// tx reads a transaction. With allow_witness, a zero input count followed
+// by a 0x01 flag is read as the BIP144 marker, as nodes do.
+func (r *reader) tx(allow_witness bool) Tx {
+	var t Tx
+	t.version = r.u32()
+	segwit := false
+	if allow_witness && r.err == nil && r.off+1 < len(r.b) && r.b[r.off] == 0x00 && r.b[r.off+1] == 0x01 {
+		r.read(2)
+		segwit = true
+	}
+	n_in := r.count(41)
+	for i := 0; i < n_in && r.err == nil; i++ {
+		tx_in := TxIn{}
+		tx_in.prev_tx = r.hash()
+		tx_in.prev_index = int(r.u32())
+		tx_in.script_sig = ByteScript{cmds: r.var_bytes()}
+		tx_in.sequence = r.u32()
+		t.tx_ins = append(t.tx_ins, tx_in)
+	}
+	n_out := r.count(9)
+	for i := 0; i < n_out && r.err == nil; i++ {
+		tx_out := TxOut{}
+		tx_out.amount = int64(r.u64())
+		tx_out.script_pubkey = ByteScript{cmds: r.var_bytes()}
+		t.tx_outs = append(t.tx_outs, tx_out)
+	}
+	if segwit {
+		has_witness := false
+		for i := range t.tx_ins {
+			t.tx_ins[i].witness = r.witness()
+			has_witness = has_witness || len(t.tx_ins[i].witness) > 0
+		}
+		if r.err == nil && !has_witness {
+			// It would not serialize back the same way.
+			r.err = fmt.Errorf("segwit marker on a transaction without witnesses")
+		}
+	}
+	t.locktime = r.u32()
+	return t
+}
+
+func (r *reader) witness() [][]byte {
+	n := r.count(1)
+	if n == 0 {
+		return nil
+	}
+	items := make([][]byte, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		items = append(items, r.var_bytes())
+	}
+	return items
+}
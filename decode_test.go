@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// The signed native P2WPKH example of BIP143: the first input spends a
+// P2PK output, the second a P2WPKH one.
+const bip143_signed_tx = "01000000000102fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f00000000494830450221008b9d1dc26ba6a9cb62127b02742fa9d754cd3bebf337f7a55d114c8e5cdd30be022040529b194ba3f9281a99f2b1c0a19c0489bc22ede944ccf4ecbab4cc618ef3ed01eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac000247304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d5447a12fb1366d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d43c212a8caed02de67eebee0121025476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee635711000000"
+
+func TestParseTx(t *testing.T) {
+	b := unhex(t, bip143_signed_tx)
+	tx, err := ParseTx(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.tx_ins) != 2 || len(tx.tx_outs) != 2 || tx.locktime != 0x11 || tx.version != 1 {
+		t.Fatalf("parsed %v inputs, %v outputs, locktime %v", len(tx.tx_ins), len(tx.tx_outs), tx.locktime)
+	}
+	if len(tx.tx_ins[0].witness) != 0 || len(tx.tx_ins[1].witness) != 2 || tx.tx_ins[0].sequence != 0xffffffee {
+		t.Error("inputs parsed wrong")
+	}
+	if tx.tx_outs[0].amount != 112340000 || tx.tx_outs[1].amount != 223450000 {
+		t.Error("amounts parsed wrong")
+	}
+	if !bytes.Equal(tx.TxEncode(-1), b) {
+		t.Error("does not serialize back the same")
+	}
+	legacy, err := ParseTx(tx.encode(-1, false))
+	if err != nil || legacy.has_witness() || legacy.TxID() != tx.TxID() || legacy.WTxID() != tx.TxID() {
+		t.Errorf("without witnesses: %v", err)
+	}
+	if tx.WTxID() == tx.TxID() {
+		t.Error("wtxid of a segwit transaction is its txid")
+	}
+
+	g, _ := GenesisBlock("main")
+	coinbase, err := ParseTx(g.txs[0].TxEncode(-1))
+	if err != nil || coinbase.TxID().String() != "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b" {
+		t.Errorf("genesis coinbase: %v", err)
+	}
+}
+
+func TestParseTxRejects(t *testing.T) {
+	b := unhex(t, bip143_signed_tx)
+	for n := 0; n < len(b); n++ {
+		if _, err := ParseTx(b[:n]); err == nil {
+			t.Fatalf("accepted the first %v of %v bytes", n, len(b))
+		}
+	}
+	if _, err := ParseTx(append(append([]byte(nil), b...), 0)); err == nil {
+		t.Error("accepted trailing data")
+	}
+	// A segwit marker with every witness empty.
+	tx, _ := ParseTx(b)
+	tx.tx_ins[1].witness = nil
+	if _, err := ParseTx(tx.encode(-1, true)); err == nil {
+		t.Error("accepted a segwit marker without witnesses")
+	}
+	// An input count the data cannot hold must not allocate.
+	huge := append([]byte{1, 0, 0, 0}, encode_varint(1<<40)...)
+	if _, err := ParseTx(huge); err == nil {
+		t.Error("accepted an input count past the end")
+	}
+}
+
+func TestReaderStickyError(t *testing.T) {
+	r := &reader{b: []byte{1, 2, 3}}
+	r.u32()
+	if r.u8() != 0 || r.varint() != 0 || r.done() == nil {
+		t.Error("reads after an error returned data")
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+// base_window is the width in bits of each window of the generator table.
+const base_window = 4
+
+// base_table holds, for every 4-bit window i of a 256-bit scalar, the affine
+// points j * 16^i * G for j = 1..15. A base point multiplication is then one
+// mixed addition per non-zero window and no doublings at all.
+type base_table struct {
+	once sync.Once
+	rows [256 / base_window][1<<base_window - 1]Point
+}
+
+func (gen Generator) precompute() *base_table {
+	gen.table.once.Do(func() {
+		B := *gen.G
+		for i := range gen.table.rows {
+			row := &gen.table.rows[i]
+			row[0] = B
+			for j := 1; j < len(row); j++ {
+				row[j] = row[j-1].elliptic_curve_addition(B)
+			}
+			B = row[len(row)-1].elliptic_curve_addition(B)
+		}
+	})
+	return gen.table
+}
+
+// base_multiply returns k*G using the precomputed table. The table is built
+// the first time it is needed.
+func (gen Generator) base_multiply(k *big.Int) Point {
+	table := gen.precompute()
+	kb := new(big.Int).Mod(k, gen.n).FillBytes(make([]byte, 32))
+	acc := gen.G.curve.jacobian_inf()
+	for i := range table.rows {
+		b := kb[31-i/2]
+		if i%2 == 1 {
+			b >>= 4
+		}
+		b &= 0x0f
+		if b != 0 {
+			acc = acc.add_affine(table.rows[i][b-1])
+		}
+	}
+	return acc.to_affine()
+}
+
+// wnaf_window is the window width used for the variable points of a multi
+// scalar multiplication: each point gets a table of its 8 odd multiples.
+const wnaf_window = 5
+
+// wnaf returns the width-w non-adjacent form of k >= 0, least significant
+// digit first. Every non-zero digit is odd and lies in (-2^(w-1), 2^(w-1)),
+// and any w consecutive digits contain at most one non-zero one.
+func wnaf(k *big.Int, w uint) []int8 {
+	d := new(big.Int).Set(k)
+	mask := big.NewInt(1<<w - 1)
+	low := new(big.Int)
+	var naf []int8
+	for d.Sign() > 0 {
+		var digit int64
+		if d.Bit(0) == 1 {
+			digit = low.And(d, mask).Int64()
+			if digit >= 1<<(w-1) {
+				digit -= 1 << w
+			}
+			d.Sub(d, low.SetInt64(digit))
+		}
+		naf = append(naf, int8(digit))
+		d.Rsh(d, 1)
+	}
+	return naf
+}
+
+// multi_scalar_multiply returns scalars[0]*points[0] + ... + scalars[n-1]*points[n-1].
+// It uses Strauss's method (Shamir's trick generalised to n points): the
+// scalars are recoded in wNAF and all of them share a single chain of
+// doublings, instead of one chain per point followed by additions.
+func multi_scalar_multiply(points []Point, scalars []*big.Int) (Point, error) {
+	if len(points) != len(scalars) {
+		return Point{}, fmt.Errorf("%v points but %v scalars", len(points), len(scalars))
+	}
+	if len(points) == 0 {
+		return Point{}, fmt.Errorf("no points to multiply")
+	}
+	curve := points[0].curve
+	nafs := make([][]int8, 0, len(points))
+	tables := make([][]jacobian, 0, len(points))
+	max_len := 0
+	for i, p := range points {
+		k := scalars[i]
+		if !p.curve.Equal(curve) {
+			return Point{}, fmt.Errorf("points are not all on the same curve")
+		}
+		if p.inf || k.Sign() == 0 {
+			continue
+		}
+		if k.Sign() < 0 {
+			p = Point{curve: p.curve, x: p.x, y: p.y.Neg()}
+			k = new(big.Int).Neg(k)
+		}
+		naf := wnaf(k, wnaf_window)
+		if len(naf) > max_len {
+			max_len = len(naf)
+		}
+		table := make([]jacobian, 1<<(wnaf_window-2))
+		table[0] = p.to_jacobian()
+		twice := table[0].double()
+		for j := 1; j < len(table); j++ {
+			table[j] = table[j-1].add(twice)
+		}
+		nafs = append(nafs, naf)
+		tables = append(tables, table)
+	}
+	if len(nafs) == 0 {
+		return curve.Infinity(), nil
+	}
+	acc := curve.jacobian_inf()
+	for bit := max_len - 1; bit >= 0; bit-- {
+		acc = acc.double()
+		for i, naf := range nafs {
+			if bit >= len(naf) {
+				continue
+			}
+			if d := naf[bit]; d > 0 {
+				acc = acc.add(tables[i][d/2])
+			} else if d < 0 {
+				acc = acc.add(tables[i][-d/2].neg())
+			}
+		}
+	}
+	return acc.to_affine(), nil
+}
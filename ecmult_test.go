@@ -0,0 +1,127 @@
+package main
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+func TestBaseMultiply(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for _, name := range CurveNames() {
+		gen, _ := LookupCurve(name)
+		ks := []*big.Int{big.NewInt(0), big.NewInt(1), big.NewInt(2), big.NewInt(15), big.NewInt(16),
+			new(big.Int).Sub(gen.n, big.NewInt(1)), new(big.Int).Set(gen.n)}
+		for i := 0; i < 20; i++ {
+			ks = append(ks, new(big.Int).Rand(r, gen.n))
+		}
+		for _, k := range ks {
+			want, err := gen.G.double_and_add(new(big.Int).Mod(k, gen.n))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := gen.base_multiply(k); !got.Compare(want) {
+				t.Fatalf("%v: %v*G = %v, want %v", name, k, got, want)
+			}
+		}
+	}
+	gen, _ := LookupCurve("secp256k1")
+	two_g := gen.base_multiply(big.NewInt(2))
+	if x := two_g.x.Big().Text(16); x != "c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5" {
+		t.Errorf("2G.x = %v", x)
+	}
+}
+
+func TestWNAF(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	for i := 0; i < 200; i++ {
+		k := new(big.Int).Rand(r, new(big.Int).Lsh(big.NewInt(1), 256))
+		naf := wnaf(k, wnaf_window)
+		sum := new(big.Int)
+		last := len(naf) + wnaf_window
+		for j := len(naf) - 1; j >= 0; j-- {
+			sum.Lsh(sum, 1).Add(sum, big.NewInt(int64(naf[j])))
+			if d := naf[j]; d != 0 {
+				if d%2 == 0 || d >= 1<<(wnaf_window-1) || d <= -(1<<(wnaf_window-1)) {
+					t.Fatalf("digit %v", d)
+				}
+				if last-j < wnaf_window {
+					t.Fatalf("non-zero digits %v and %v are too close", last, j)
+				}
+				last = j
+			}
+		}
+		if sum.Cmp(k) != 0 {
+			t.Fatalf("wnaf(%x) sums to %x", k, sum)
+		}
+	}
+}
+
+func TestMultiScalarMultiply(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	for _, name := range CurveNames() {
+		gen, _ := LookupCurve(name)
+		for n := 1; n <= 4; n++ {
+			var points []Point
+			var scalars []*big.Int
+			want := gen.G.curve.Infinity()
+			for i := 0; i < n; i++ {
+				p := gen.base_multiply(new(big.Int).Rand(r, gen.n))
+				k := new(big.Int).Rand(r, gen.n)
+				switch r.Intn(4) {
+				case 0:
+					k.SetInt64(0)
+				case 1:
+					p = gen.G.curve.Infinity()
+				}
+				points = append(points, p)
+				scalars = append(scalars, k)
+				kp, _ := p.double_and_add(k)
+				want = want.elliptic_curve_addition(kp)
+			}
+			got, err := multi_scalar_multiply(points, scalars)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Compare(want) {
+				t.Fatalf("%v: got %v, want %v", name, got, want)
+			}
+		}
+	}
+	gen, _ := LookupCurve("secp256k1")
+	// Negative scalars negate the point.
+	minus, _ := multi_scalar_multiply([]Point{*gen.G}, []*big.Int{big.NewInt(-3)})
+	if !minus.Compare(gen.base_multiply(new(big.Int).Sub(gen.n, big.NewInt(3)))) {
+		t.Error("-3*G")
+	}
+	if _, err := multi_scalar_multiply([]Point{*gen.G}, nil); err == nil {
+		t.Error("mismatched lengths")
+	}
+}
+
+// TestVerify signs by hand with a fixed nonce and checks verify, which
+// uses multi_scalar_multiply.
+func TestVerify(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	secret := big.NewInt(12345)
+	pub := gen.base_multiply(secret)
+	message := []byte("verify me")
+	z_hash := Hash256(message)
+	z := NewScalar(gen.fn, new(big.Int).SetBytes(z_hash[:]))
+	k := big.NewInt(987654321)
+	r := NewScalar(gen.fn, gen.base_multiply(k).x.Big())
+	s := NewScalar(gen.fn, secret).Mul(r).Add(z).Mul(NewScalar(gen.fn, k).Inverse())
+	sig := Signature{r: r, s: s}
+	if !verify(pub, gen, message, sig) {
+		t.Fatal("valid signature rejected")
+	}
+	if !verify(pub, gen, message, Signature{r: r, s: s.Neg()}) {
+		t.Fatal("negated s rejected")
+	}
+	if verify(pub, gen, []byte("verify you"), sig) {
+		t.Fatal("signature accepted for another message")
+	}
+	if verify(gen.base_multiply(big.NewInt(12346)), gen, message, sig) {
+		t.Fatal("signature accepted for another key")
+	}
+}
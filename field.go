@@ -0,0 +1,304 @@
+package main
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// Modulus holds an odd modulus of at most 256 bits together with the
+// constants needed for Montgomery multiplication. Values are kept as 4x64-bit
+// little-endian limbs in Montgomery form (x*R mod m with R = 2^256), so none of
+// the arithmetic below allocates.
+type Modulus struct {
+	m        [4]uint64
+	n0       uint64    // -m^-1 mod 2^64
+	rr       [4]uint64 // R^2 mod m
+	one      [4]uint64 // R mod m
+	inv_exp  [4]uint64 // m - 2
+	sqrt_exp [4]uint64 // (m + 1) / 4, only meaningful when m = 3 mod 4
+	big      *big.Int
+}
+
+func NewModulus(m *big.Int) *Modulus {
+	if m.Sign() <= 0 || m.Bit(0) == 0 || m.BitLen() > 256 {
+		panic("modulus must be odd, positive and at most 256 bits")
+	}
+	mod := &Modulus{
+		m:   limbs(m),
+		big: new(big.Int).Set(m),
+	}
+	inv := uint64(1)
+	for i := 0; i < 6; i++ {
+		inv *= 2 - mod.m[0]*inv
+	}
+	mod.n0 = -inv
+	r := new(big.Int).Lsh(big.NewInt(1), 256)
+	mod.one = limbs(new(big.Int).Mod(r, m))
+	mod.rr = limbs(r.Mod(r.Mul(r, r), m))
+	mod.inv_exp = limbs(new(big.Int).Sub(m, big.NewInt(2)))
+	sqrt_exp := new(big.Int).Add(m, big.NewInt(1))
+	mod.sqrt_exp = limbs(sqrt_exp.Rsh(sqrt_exp, 2))
+	return mod
+}
+
+// limbs converts a non-negative integer below 2^256 into little-endian limbs.
+func limbs(x *big.Int) [4]uint64 {
+	var buf [32]byte
+	x.FillBytes(buf[:])
+	return limbs_from_bytes(buf[:])
+}
+
+func limbs_from_bytes(b []byte) [4]uint64 {
+	var l [4]uint64
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 8; j++ {
+			l[3-i] = l[3-i]<<8 | uint64(b[i*8+j])
+		}
+	}
+	return l
+}
+
+func limbs_to_bytes(l [4]uint64) []byte {
+	b := make([]byte, 32)
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 8; j++ {
+			b[i*8+j] = byte(l[3-i] >> (56 - 8*uint(j)))
+		}
+	}
+	return b
+}
+
+func limbs_less(a, b [4]uint64) bool {
+	for i := 3; i >= 0; i-- {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+func limbs_sub(a, b [4]uint64) (d [4]uint64, borrow uint64) {
+	d[0], borrow = bits.Sub64(a[0], b[0], 0)
+	d[1], borrow = bits.Sub64(a[1], b[1], borrow)
+	d[2], borrow = bits.Sub64(a[2], b[2], borrow)
+	d[3], borrow = bits.Sub64(a[3], b[3], borrow)
+	return d, borrow
+}
+
+func (mod *Modulus) add(a, b [4]uint64) [4]uint64 {
+	var s [4]uint64
+	var carry uint64
+	s[0], carry = bits.Add64(a[0], b[0], 0)
+	s[1], carry = bits.Add64(a[1], b[1], carry)
+	s[2], carry = bits.Add64(a[2], b[2], carry)
+	s[3], carry = bits.Add64(a[3], b[3], carry)
+	if carry != 0 || !limbs_less(s, mod.m) {
+		s, _ = limbs_sub(s, mod.m)
+	}
+	return s
+}
+
+func (mod *Modulus) sub(a, b [4]uint64) [4]uint64 {
+	d, borrow := limbs_sub(a, b)
+	if borrow != 0 {
+		var carry uint64
+		d[0], carry = bits.Add64(d[0], mod.m[0], 0)
+		d[1], carry = bits.Add64(d[1], mod.m[1], carry)
+		d[2], carry = bits.Add64(d[2], mod.m[2], carry)
+		d[3], _ = bits.Add64(d[3], mod.m[3], carry)
+	}
+	return d
+}
+
+// mul returns a*b*R^-1 mod m using the CIOS Montgomery method.
+func (mod *Modulus) mul(a, b [4]uint64) [4]uint64 {
+	var t [6]uint64
+	for i := 0; i < 4; i++ {
+		var c uint64
+		for j := 0; j < 4; j++ {
+			hi, lo := bits.Mul64(a[j], b[i])
+			var c1, c2 uint64
+			lo, c1 = bits.Add64(lo, t[j], 0)
+			lo, c2 = bits.Add64(lo, c, 0)
+			t[j], c = lo, hi+c1+c2
+		}
+		t[4], c = bits.Add64(t[4], c, 0)
+		t[5] = c
+
+		u := t[0] * mod.n0
+		hi, lo := bits.Mul64(u, mod.m[0])
+		_, c1 := bits.Add64(lo, t[0], 0)
+		c = hi + c1
+		for j := 1; j < 4; j++ {
+			hi, lo = bits.Mul64(u, mod.m[j])
+			var c2 uint64
+			lo, c1 = bits.Add64(lo, t[j], 0)
+			lo, c2 = bits.Add64(lo, c, 0)
+			t[j-1], c = lo, hi+c1+c2
+		}
+		t[3], c = bits.Add64(t[4], c, 0)
+		t[4] = t[5] + c
+	}
+	r := [4]uint64{t[0], t[1], t[2], t[3]}
+	if t[4] != 0 || !limbs_less(r, mod.m) {
+		r, _ = limbs_sub(r, mod.m)
+	}
+	return r
+}
+
+// exp raises a (Montgomery form) to the plain integer power e.
+func (mod *Modulus) exp(a, e [4]uint64) [4]uint64 {
+	r := mod.one
+	for i := 255; i >= 0; i-- {
+		r = mod.mul(r, r)
+		if (e[i/64]>>(uint(i)%64))&1 == 1 {
+			r = mod.mul(r, a)
+		}
+	}
+	return r
+}
+
+func (mod *Modulus) to_mont(x *big.Int) [4]uint64 {
+	r := new(big.Int).Mod(x, mod.big)
+	return mod.mul(limbs(r), mod.rr)
+}
+
+func (mod *Modulus) from_mont(a [4]uint64) [4]uint64 {
+	return mod.mul(a, [4]uint64{1, 0, 0, 0})
+}
+
+// FieldElement is an element of the prime field a curve is defined over.
+type FieldElement struct {
+	mod *Modulus
+	v   [4]uint64
+}
+
+func NewFieldElement(mod *Modulus, x *big.Int) FieldElement {
+	return FieldElement{mod: mod, v: mod.to_mont(x)}
+}
+
+func (a FieldElement) Add(b FieldElement) FieldElement {
+	return FieldElement{mod: a.mod, v: a.mod.add(a.v, b.v)}
+}
+
+func (a FieldElement) Sub(b FieldElement) FieldElement {
+	return FieldElement{mod: a.mod, v: a.mod.sub(a.v, b.v)}
+}
+
+func (a FieldElement) Neg() FieldElement {
+	return FieldElement{mod: a.mod, v: a.mod.sub([4]uint64{}, a.v)}
+}
+
+func (a FieldElement) Mul(b FieldElement) FieldElement {
+	return FieldElement{mod: a.mod, v: a.mod.mul(a.v, b.v)}
+}
+
+func (a FieldElement) Square() FieldElement {
+	return FieldElement{mod: a.mod, v: a.mod.mul(a.v, a.v)}
+}
+
+// Inverse uses Fermat's little theorem, a^(p-2) = a^-1. The inverse of zero is zero.
+func (a FieldElement) Inverse() FieldElement {
+	return FieldElement{mod: a.mod, v: a.mod.exp(a.v, a.mod.inv_exp)}
+}
+
+// Sqrt returns a square root of a and whether one exists. Only primes
+// p = 3 mod 4 are supported, which covers every curve we use.
+func (a FieldElement) Sqrt() (FieldElement, bool) {
+	if a.mod.m[0]&3 != 3 {
+		return FieldElement{}, false
+	}
+	r := FieldElement{mod: a.mod, v: a.mod.exp(a.v, a.mod.sqrt_exp)}
+	return r, r.Square().Equal(a)
+}
+
+func (a FieldElement) IsZero() bool {
+	return a.v == [4]uint64{}
+}
+
+func (a FieldElement) Equal(b FieldElement) bool {
+	return a.v == b.v
+}
+
+func (a FieldElement) IsOdd() bool {
+	return a.mod.from_mont(a.v)[0]&1 == 1
+}
+
+// Bytes returns the 32 byte big endian encoding of a.
+func (a FieldElement) Bytes() []byte {
+	return limbs_to_bytes(a.mod.from_mont(a.v))
+}
+
+func (a FieldElement) Big() *big.Int {
+	return new(big.Int).SetBytes(a.Bytes())
+}
+
+func (a FieldElement) String() string {
+	if a.mod == nil {
+		return "0"
+	}
+	return a.Big().String()
+}
+
+// Scalar is an integer modulo the order n of a generator.
+type Scalar struct {
+	mod *Modulus
+	v   [4]uint64
+}
+
+func NewScalar(mod *Modulus, x *big.Int) Scalar {
+	return Scalar{mod: mod, v: mod.to_mont(x)}
+}
+
+func (a Scalar) Add(b Scalar) Scalar {
+	return Scalar{mod: a.mod, v: a.mod.add(a.v, b.v)}
+}
+
+func (a Scalar) Sub(b Scalar) Scalar {
+	return Scalar{mod: a.mod, v: a.mod.sub(a.v, b.v)}
+}
+
+func (a Scalar) Neg() Scalar {
+	return Scalar{mod: a.mod, v: a.mod.sub([4]uint64{}, a.v)}
+}
+
+func (a Scalar) Mul(b Scalar) Scalar {
+	return Scalar{mod: a.mod, v: a.mod.mul(a.v, b.v)}
+}
+
+func (a Scalar) Square() Scalar {
+	return Scalar{mod: a.mod, v: a.mod.mul(a.v, a.v)}
+}
+
+func (a Scalar) Inverse() Scalar {
+	return Scalar{mod: a.mod, v: a.mod.exp(a.v, a.mod.inv_exp)}
+}
+
+func (a Scalar) IsZero() bool {
+	return a.v == [4]uint64{}
+}
+
+func (a Scalar) Equal(b Scalar) bool {
+	return a.v == b.v
+}
+
+// IsHigh reports whether a is larger than n/2.
+func (a Scalar) IsHigh() bool {
+	half := new(big.Int).Rsh(a.mod.big, 1)
+	return a.Big().Cmp(half) == 1
+}
+
+func (a Scalar) Bytes() []byte {
+	return limbs_to_bytes(a.mod.from_mont(a.v))
+}
+
+func (a Scalar) Big() *big.Int {
+	return new(big.Int).SetBytes(a.Bytes())
+}
+
+func (a Scalar) String() string {
+	if a.mod == nil {
+		return "0"
+	}
+	return a.Big().String()
+}
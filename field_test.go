@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+// random_below returns a random integer in [0, m), biased towards the edges
+// where carries and reductions go wrong.
+func random_below(r *rand.Rand, m *big.Int) *big.Int {
+	switch r.Intn(8) {
+	case 0:
+		return big.NewInt(int64(r.Intn(3)))
+	case 1:
+		return new(big.Int).Sub(m, big.NewInt(int64(1+r.Intn(3))))
+	}
+	return new(big.Int).Rand(r, m)
+}
+
+// TestFieldDifferential checks the Montgomery arithmetic against math/big.
+func TestFieldDifferential(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	r := rand.New(rand.NewSource(1))
+	for _, mod := range []*Modulus{gen.G.curve.fp, gen.fn} {
+		m := mod.big
+		for i := 0; i < 2000; i++ {
+			x, y := random_below(r, m), random_below(r, m)
+			a, b := NewFieldElement(mod, x), NewFieldElement(mod, y)
+			check := func(name string, got FieldElement, want *big.Int) {
+				t.Helper()
+				if got.Big().Cmp(want.Mod(want, m)) != 0 {
+					t.Fatalf("%s(%x, %x) = %x, want %x", name, x, y, got.Big(), want)
+				}
+			}
+			check("add", a.Add(b), new(big.Int).Add(x, y))
+			check("sub", a.Sub(b), new(big.Int).Sub(x, y))
+			check("neg", a.Neg(), new(big.Int).Neg(x))
+			check("mul", a.Mul(b), new(big.Int).Mul(x, y))
+			check("square", a.Square(), new(big.Int).Mul(x, x))
+			if x.Sign() != 0 {
+				check("inverse", a.Inverse(), new(big.Int).ModInverse(x, m))
+			}
+			if a.IsOdd() != (x.Bit(0) == 1) {
+				t.Fatalf("IsOdd(%x)", x)
+			}
+		}
+	}
+}
+
+func TestFieldSqrt(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	fp := gen.G.curve.fp
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 200; i++ {
+		x := NewFieldElement(fp, random_below(r, fp.big))
+		root, ok := x.Square().Sqrt()
+		if !ok || !root.Square().Equal(x.Square()) {
+			t.Fatalf("no square root of %v squared", x)
+		}
+	}
+	// -1 is not a square modulo p = 3 mod 4.
+	if _, ok := NewFieldElement(fp, big.NewInt(1)).Neg().Sqrt(); ok {
+		t.Fatal("found a square root of -1")
+	}
+}
+
+func TestScalarIsHigh(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	half := new(big.Int).Rsh(gen.n, 1)
+	if NewScalar(gen.fn, half).IsHigh() {
+		t.Error("n/2 is not high")
+	}
+	if !NewScalar(gen.fn, new(big.Int).Add(half, big.NewInt(1))).IsHigh() {
+		t.Error("n/2+1 is high")
+	}
+}
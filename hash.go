@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"math"
+	"math/big"
+	"math/bits"
+)
+
+// Streaming versions of sha256 and ripemd160 that implement hash.Hash, so
+// data can be hashed as it arrives (e.g. from a large file) and the digests
+// can be handed to anything that accepts a hash.Hash. The arithmetic is done
+// on uint32 words, which wrap around mod 2^32 on their own.
+
+// The round constants are derived by genK and genH from the first 64
+// (resp. 8) primes.
+var sha256_k = func() (k [64]uint32) {
+	for i, v := range genK() {
+		k[i] = uint32(v.Uint64())
+	}
+	return k
+}()
+
+var sha256_h = func() (h [8]uint32) {
+	for i, v := range genH() {
+		h[i] = uint32(v.Uint64())
+	}
+	return h
+}()
+
+type sha256_digest struct {
+	h    [8]uint32
+	buf  [64]byte
+	nbuf int
+	len  uint64
+}
+
+func NewSHA256() hash.Hash {
+	d := new(sha256_digest)
+	d.Reset()
+	return d
+}
+
+func (d *sha256_digest) Reset() {
+	d.h = sha256_h
+	d.nbuf = 0
+	d.len = 0
+}
+
+func (d *sha256_digest) Size() int { return 32 }
+
+func (d *sha256_digest) BlockSize() int { return 64 }
+
+func (d *sha256_digest) Write(p []byte) (int, error) {
+	n := len(p)
+	d.len += uint64(n)
+	if d.nbuf > 0 {
+		c := copy(d.buf[d.nbuf:], p)
+		d.nbuf += c
+		p = p[c:]
+		if d.nbuf < 64 {
+			return n, nil
+		}
+		d.block(d.buf[:])
+		d.nbuf = 0
+	}
+	for len(p) >= 64 {
+		d.block(p[:64])
+		p = p[64:]
+	}
+	d.nbuf = copy(d.buf[:], p)
+	return n, nil
+}
+
+// Sum appends the digest of everything written so far to b. It does not
+// change the state, so more data can still be written afterwards.
+func (d *sha256_digest) Sum(b []byte) []byte {
+	c := *d
+	bit_len := c.len * 8
+	padding := make([]byte, 64+8)
+	padding[0] = 0x80
+	pad_len := 56 - int(c.len%64)
+	if pad_len <= 0 {
+		pad_len += 64
+	}
+	binary.BigEndian.PutUint64(padding[pad_len:], bit_len)
+	c.Write(padding[:pad_len+8])
+	for _, h := range c.h {
+		b = append(b, byte(h>>24), byte(h>>16), byte(h>>8), byte(h))
+	}
+	return b
+}
+
+func (d *sha256_digest) block(p []byte) {
+	var w [64]uint32
+	for t := 0; t < 16; t++ {
+		w[t] = binary.BigEndian.Uint32(p[t*4:])
+	}
+	for t := 16; t < 64; t++ {
+		s0 := bits.RotateLeft32(w[t-15], -7) ^ bits.RotateLeft32(w[t-15], -18) ^ (w[t-15] >> 3)
+		s1 := bits.RotateLeft32(w[t-2], -17) ^ bits.RotateLeft32(w[t-2], -19) ^ (w[t-2] >> 10)
+		w[t] = s1 + w[t-7] + s0 + w[t-16]
+	}
+	a, b, c, dd, e, f, g, h := d.h[0], d.h[1], d.h[2], d.h[3], d.h[4], d.h[5], d.h[6], d.h[7]
+	for t := 0; t < 64; t++ {
+		S1 := bits.RotateLeft32(e, -6) ^ bits.RotateLeft32(e, -11) ^ bits.RotateLeft32(e, -25)
+		ch := (e & f) ^ (^e & g)
+		tmp1 := h + S1 + ch + sha256_k[t] + w[t]
+		S0 := bits.RotateLeft32(a, -2) ^ bits.RotateLeft32(a, -13) ^ bits.RotateLeft32(a, -22)
+		maj := (a & b) ^ (a & c) ^ (b & c)
+		tmp2 := S0 + maj
+		h, g, f, e, dd, c, b, a = g, f, e, dd+tmp1, c, b, a, tmp1+tmp2
+	}
+	d.h[0] += a
+	d.h[1] += b
+	d.h[2] += c
+	d.h[3] += dd
+	d.h[4] += e
+	d.h[5] += f
+	d.h[6] += g
+	d.h[7] += h
+}
+
+// RIPEMD-160 message word selection (r) and rotation amounts (s) for each of
+// the 80 steps of the left and right lines.
+var rmd_r = [80]uint8{
+	0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+	7, 4, 13, 1, 10, 6, 15, 3, 12, 0, 9, 5, 2, 14, 11, 8,
+	3, 10, 14, 4, 9, 15, 8, 1, 2, 7, 0, 6, 13, 11, 5, 12,
+	1, 9, 11, 10, 0, 8, 12, 4, 13, 3, 7, 15, 14, 5, 6, 2,
+	4, 0, 5, 9, 7, 12, 2, 10, 14, 1, 3, 8, 11, 6, 15, 13,
+}
+
+var rmd_rr = [80]uint8{
+	5, 14, 7, 0, 9, 2, 11, 4, 13, 6, 15, 8, 1, 10, 3, 12,
+	6, 11, 3, 7, 0, 13, 5, 10, 14, 15, 8, 12, 4, 9, 1, 2,
+	15, 5, 1, 3, 7, 14, 6, 9, 11, 8, 12, 2, 10, 0, 4, 13,
+	8, 6, 4, 1, 3, 11, 15, 0, 5, 12, 2, 13, 9, 7, 10, 14,
+	12, 15, 10, 4, 1, 5, 8, 7, 6, 2, 13, 14, 0, 3, 9, 11,
+}
+
+var rmd_s = [80]uint8{
+	11, 14, 15, 12, 5, 8, 7, 9, 11, 13, 14, 15, 6, 7, 9, 8,
+	7, 6, 8, 13, 11, 9, 7, 15, 7, 12, 15, 9, 11, 7, 13, 12,
+	11, 13, 6, 7, 14, 9, 13, 15, 14, 8, 13, 6, 5, 12, 7, 5,
+	11, 12, 14, 15, 14, 15, 9, 8, 9, 14, 5, 6, 8, 6, 5, 12,
+	9, 15, 5, 11, 6, 8, 13, 12, 5, 12, 13, 14, 11, 8, 5, 6,
+}
+
+var rmd_ss = [80]uint8{
+	8, 9, 9, 11, 13, 15, 15, 5, 7, 7, 8, 11, 14, 14, 12, 6,
+	9, 13, 15, 7, 12, 8, 9, 11, 7, 7, 12, 7, 6, 15, 13, 11,
+	9, 7, 15, 11, 8, 6, 6, 14, 12, 13, 5, 14, 13, 13, 7, 5,
+	15, 5, 8, 11, 14, 14, 6, 14, 6, 9, 12, 9, 12, 5, 15, 8,
+	8, 5, 12, 9, 12, 5, 14, 6, 8, 13, 6, 5, 15, 13, 11, 11,
+}
+
+var rmd_k = [5]uint32{0x00000000, 0x5A827999, 0x6ED9EBA1, 0x8F1BBCDC, 0xA953FD4E}
+
+var rmd_kk = [5]uint32{0x50A28BE6, 0x5C4DD124, 0x6D703EF3, 0x7A6D76E9, 0x00000000}
+
+// rmd_f is the boolean function of round j (0..4).
+func rmd_f(j int, x, y, z uint32) uint32 {
+	switch j {
+	case 0:
+		return x ^ y ^ z
+	case 1:
+		return (x & y) | (^x & z)
+	case 2:
+		return (x | ^y) ^ z
+	case 3:
+		return (x & z) | (y & ^z)
+	default:
+		return x ^ (y | ^z)
+	}
+}
+
+type ripemd160_digest struct {
+	h    [5]uint32
+	buf  [64]byte
+	nbuf int
+	len  uint64
+}
+
+func NewRIPEMD160() hash.Hash {
+	d := new(ripemd160_digest)
+	d.Reset()
+	return d
+}
+
+func (d *ripemd160_digest) Reset() {
+	d.h = [5]uint32{0x67452301, 0xEFCDAB89, 0x98BADCFE, 0x10325476, 0xC3D2E1F0}
+	d.nbuf = 0
+	d.len = 0
+}
+
+func (d *ripemd160_digest) Size() int { return 20 }
+
+func (d *ripemd160_digest) BlockSize() int { return 64 }
+
+func (d *ripemd160_digest) Write(p []byte) (int, error) {
+	n := len(p)
+	d.len += uint64(n)
+	if d.nbuf > 0 {
+		c := copy(d.buf[d.nbuf:], p)
+		d.nbuf += c
+		p = p[c:]
+		if d.nbuf < 64 {
+			return n, nil
+		}
+		d.block(d.buf[:])
+		d.nbuf = 0
+	}
+	for len(p) >= 64 {
+		d.block(p[:64])
+		p = p[64:]
+	}
+	d.nbuf = copy(d.buf[:], p)
+	return n, nil
+}
+
+func (d *ripemd160_digest) Sum(b []byte) []byte {
+	c := *d
+	bit_len := c.len * 8
+	padding := make([]byte, 64+8)
+	padding[0] = 0x80
+	pad_len := 56 - int(c.len%64)
+	if pad_len <= 0 {
+		pad_len += 64
+	}
+	binary.LittleEndian.PutUint64(padding[pad_len:], bit_len)
+	c.Write(padding[:pad_len+8])
+	for _, h := range c.h {
+		b = append(b, byte(h), byte(h>>8), byte(h>>16), byte(h>>24))
+	}
+	return b
+}
+
+func (d *ripemd160_digest) block(p []byte) {
+	var x [16]uint32
+	for i := range x {
+		x[i] = binary.LittleEndian.Uint32(p[i*4:])
+	}
+	a, b, c, dd, e := d.h[0], d.h[1], d.h[2], d.h[3], d.h[4]
+	aa, bb, cc, ddd, ee := a, b, c, dd, e
+	for j := 0; j < 80; j++ {
+		round := j / 16
+		t := bits.RotateLeft32(a+rmd_f(round, b, c, dd)+x[rmd_r[j]]+rmd_k[round], int(rmd_s[j])) + e
+		a, e, dd, c, b = e, dd, bits.RotateLeft32(c, 10), b, t
+		t = bits.RotateLeft32(aa+rmd_f(4-round, bb, cc, ddd)+x[rmd_rr[j]]+rmd_kk[round], int(rmd_ss[j])) + ee
+		aa, ee, ddd, cc, bb = ee, ddd, bits.RotateLeft32(cc, 10), bb, t
+	}
+	t := d.h[1] + c + ddd
+	d.h[1] = d.h[2] + dd + ee
+	d.h[2] = d.h[3] + e + aa
+	d.h[3] = d.h[4] + a + bb
+	d.h[4] = d.h[0] + b + cc
+	d.h[0] = t
+}
+
+// frac_bin64 returns the first 64 bits of the fractional part of x.
+func frac_bin64(x *big.Float) uint64 {
+	i, _ := x.Int(nil)
+	f := new(big.Float).SetPrec(x.Prec()).Sub(x, new(big.Float).SetInt(i))
+	f.SetMantExp(f, 64)
+	u, _ := f.Uint64()
+	return u
+}
+
+// cbrt finds the cube root of n with Newton's method, x <- (2x + n/x^2) / 3.
+func cbrt(n *big.Int, prec uint) *big.Float {
+	nf := new(big.Float).SetPrec(prec).SetInt(n)
+	x := new(big.Float).SetPrec(prec).SetFloat64(math.Cbrt(float64(n.Int64())))
+	three := new(big.Float).SetPrec(prec).SetInt64(3)
+	for i := 0; i < 10; i++ {
+		t := new(big.Float).SetPrec(prec).Mul(x, x)
+		t.Quo(nf, t)
+		t.Add(t, x).Add(t, x)
+		x.Quo(t, three)
+	}
+	return x
+}
+
+// The SHA-512 constants come from the same recipe as SHA-256's, only with
+// 64 bits of the fractional parts and 80 primes. float64 does not have
+// enough precision for that, so big.Float is used.
+var sha512_k = func() (k [80]uint64) {
+	for i, p := range first_n_primes(80) {
+		k[i] = frac_bin64(cbrt(p, 256))
+	}
+	return k
+}()
+
+var sha512_h = func() (h [8]uint64) {
+	for i, p := range first_n_primes(8) {
+		h[i] = frac_bin64(new(big.Float).SetPrec(256).SetInt(p).Sqrt(new(big.Float).SetPrec(256).SetInt(p)))
+	}
+	return h
+}()
+
+type sha512_digest struct {
+	h    [8]uint64
+	buf  [128]byte
+	nbuf int
+	len  uint64
+}
+
+func NewSHA512() hash.Hash {
+	d := new(sha512_digest)
+	d.Reset()
+	return d
+}
+
+func (d *sha512_digest) Reset() {
+	d.h = sha512_h
+	d.nbuf = 0
+	d.len = 0
+}
+
+func (d *sha512_digest) Size() int { return 64 }
+
+func (d *sha512_digest) BlockSize() int { return 128 }
+
+func (d *sha512_digest) Write(p []byte) (int, error) {
+	n := len(p)
+	d.len += uint64(n)
+	if d.nbuf > 0 {
+		c := copy(d.buf[d.nbuf:], p)
+		d.nbuf += c
+		p = p[c:]
+		if d.nbuf < 128 {
+			return n, nil
+		}
+		d.block(d.buf[:])
+		d.nbuf = 0
+	}
+	for len(p) >= 128 {
+		d.block(p[:128])
+		p = p[128:]
+	}
+	d.nbuf = copy(d.buf[:], p)
+	return n, nil
+}
+
+// Sum pads with a 128 bit message length, of which we only ever need the low 64 bits.
+func (d *sha512_digest) Sum(b []byte) []byte {
+	c := *d
+	padding := make([]byte, 128+16)
+	padding[0] = 0x80
+	pad_len := 112 - int(c.len%128)
+	if pad_len <= 0 {
+		pad_len += 128
+	}
+	binary.BigEndian.PutUint64(padding[pad_len+8:], c.len*8)
+	c.Write(padding[:pad_len+16])
+	for _, h := range c.h {
+		b = append(b, byte(h>>56), byte(h>>48), byte(h>>40), byte(h>>32), byte(h>>24), byte(h>>16), byte(h>>8), byte(h))
+	}
+	return b
+}
+
+func (d *sha512_digest) block(p []byte) {
+	var w [80]uint64
+	for t := 0; t < 16; t++ {
+		w[t] = binary.BigEndian.Uint64(p[t*8:])
+	}
+	for t := 16; t < 80; t++ {
+		s0 := bits.RotateLeft64(w[t-15], -1) ^ bits.RotateLeft64(w[t-15], -8) ^ (w[t-15] >> 7)
+		s1 := bits.RotateLeft64(w[t-2], -19) ^ bits.RotateLeft64(w[t-2], -61) ^ (w[t-2] >> 6)
+		w[t] = s1 + w[t-7] + s0 + w[t-16]
+	}
+	a, b, c, dd, e, f, g, h := d.h[0], d.h[1], d.h[2], d.h[3], d.h[4], d.h[5], d.h[6], d.h[7]
+	for t := 0; t < 80; t++ {
+		S1 := bits.RotateLeft64(e, -14) ^ bits.RotateLeft64(e, -18) ^ bits.RotateLeft64(e, -41)
+		ch := (e & f) ^ (^e & g)
+		tmp1 := h + S1 + ch + sha512_k[t] + w[t]
+		S0 := bits.RotateLeft64(a, -28) ^ bits.RotateLeft64(a, -34) ^ bits.RotateLeft64(a, -39)
+		maj := (a & b) ^ (a & c) ^ (b & c)
+		tmp2 := S0 + maj
+		h, g, f, e, dd, c, b, a = g, f, e, dd+tmp1, c, b, a, tmp1+tmp2
+	}
+	d.h[0] += a
+	d.h[1] += b
+	d.h[2] += c
+	d.h[3] += dd
+	d.h[4] += e
+	d.h[5] += f
+	d.h[6] += g
+	d.h[7] += h
+}
+
+func sha512(b []byte) []byte {
+	d := NewSHA512()
+	d.Write(b)
+	return d.Sum(nil)
+}
+
+// hmac computes HMAC (RFC 2104) with the hash function made by h:
+// H((K ^ opad) || H((K ^ ipad) || message)), where K is the key padded (or
+// first hashed, if it is too long) to the block size of the hash.
+func hmac(h func() hash.Hash, key, message []byte) []byte {
+	d := h()
+	if len(key) > d.BlockSize() {
+		d.Write(key)
+		key = d.Sum(nil)
+		d.Reset()
+	}
+	ipad := make([]byte, d.BlockSize())
+	opad := make([]byte, d.BlockSize())
+	copy(ipad, key)
+	copy(opad, key)
+	for i := range ipad {
+		ipad[i] ^= 0x36
+		opad[i] ^= 0x5c
+	}
+	d.Write(ipad)
+	d.Write(message)
+	inner := d.Sum(nil)
+	d.Reset()
+	d.Write(opad)
+	d.Write(inner)
+	return d.Sum(nil)
+}
+
+func hmac_sha256(key, message []byte) []byte {
+	return hmac(NewSHA256, key, message)
+}
+
+func hmac_sha512(key, message []byte) []byte {
+	return hmac(NewSHA512, key, message)
+}
+
+// tagged_hash is the BIP340 tagged hash sha256(sha256(tag) || sha256(tag) || msg),
+// which keeps hashes computed for different purposes from ever colliding.
+func tagged_hash(tag string, msg []byte) []byte {
+	tag_hash := sha256([]byte(tag))
+	return sha256(bytes.Join([][]byte{tag_hash, tag_hash, msg}, []byte("")))
+}
+
+// Hash is a 32 byte digest such as a txid or a block hash, stored in the
+// order the bytes come out of the hash function. That is also the order used
+// inside transactions and blocks, but humans (block explorers, RPCs) show
+// these hashes reversed, so String and ParseHash work in that display order.
+type Hash [32]byte
+
+// Hash256 is sha256(sha256(b)), used for txids, block hashes and checksums.
+func Hash256(b []byte) Hash {
+	var h Hash
+	copy(h[:], sha256(sha256(b)))
+	return h
+}
+
+// Hash160 is ripemd160(sha256(b)), used to shorten public keys and scripts.
+func Hash160(b []byte) []byte {
+	return ripemd160(sha256(b))
+}
+
+func (h Hash) String() string {
+	r := h
+	reverse(r[:])
+	return hex.EncodeToString(r[:])
+}
+
+// ParseHash reads a hash written in display order, e.g. a txid copied from a
+// block explorer.
+func ParseHash(s string) (Hash, error) {
+	var h Hash
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return h, err
+	}
+	if len(b) != len(h) {
+		return h, fmt.Errorf("hash must be %v bytes, got %v", len(h), len(b))
+	}
+	copy(h[:], b)
+	reverse(h[:])
+	return h, nil
+}
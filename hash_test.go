@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	stdhmac "crypto/hmac"
+	stdsha256 "crypto/sha256"
+	stdsha512 "crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+type hash_vector struct{ in, out string }
+
+func check_vectors(t *testing.T, name string, f func([]byte) []byte, vectors []hash_vector) {
+	t.Helper()
+	for _, v := range vectors {
+		if got := hex.EncodeToString(f([]byte(v.in))); got != v.out {
+			t.Errorf("%v(%.20q) = %v, want %v", name, v.in, got, v.out)
+		}
+	}
+}
+
+func TestSHA256(t *testing.T) {
+	check_vectors(t, "sha256", sha256, []hash_vector{
+		{"", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"abc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"abcdbcdecdefdefgefghfghighijhijkijkljklmklmnlmnomnopnopq", "248d6a61d20638b8e5c026930c3e6039a33ce45964ff2167f6ecedd419db06c1"},
+		{strings.Repeat("a", 1000000), "cdc76e5c9914fb9281a1c7e284d73e67f1809a48a497200e046d39ccc7112cd0"},
+	})
+	// Every length around the block and padding boundaries, against the
+	// standard library.
+	r := rand.New(rand.NewSource(6))
+	for n := 0; n < 300; n++ {
+		b := make([]byte, n)
+		r.Read(b)
+		want := stdsha256.Sum256(b)
+		if got := sha256(b); !bytes.Equal(got, want[:]) {
+			t.Fatalf("sha256 of %v bytes = %x, want %x", n, got, want)
+		}
+	}
+}
+
+// TestSHA256Random compares sha256 with the standard library on millions of
+// random inputs. Half the lengths are within a few bytes of where the
+// padding spills into another block (55/56 bytes into a block) or where the
+// block ends (64), the others anywhere up to four blocks.
+func TestSHA256Random(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the randomized sha256 test in short mode")
+	}
+	r := rand.New(rand.NewSource(33))
+	buf := make([]byte, 4*64+8)
+	for i := 0; i < 2000000; i++ {
+		n := r.Intn(len(buf))
+		if i%2 == 0 {
+			boundary := []int{55, 56, 64}[r.Intn(3)]
+			n = 64*r.Intn(4) + boundary + r.Intn(5) - 2
+		}
+		b := buf[:n]
+		r.Read(b)
+		want := stdsha256.Sum256(b)
+		if got := sha256(b); !bytes.Equal(got, want[:]) {
+			t.Fatalf("case %v: sha256(%x) = %x, want %x", i, b, got, want)
+		}
+	}
+}
+
+func TestRIPEMD160(t *testing.T) {
+	check_vectors(t, "ripemd160", ripemd160, []hash_vector{
+		{"", "9c1185a5c5e9fc54612808977ee8f548b2258d31"},
+		{"abc", "8eb208f7e05d987a9b044a8e98c6b087f15a0bfc"},
+		{"message digest", "5d0689ef49d2fae572b881b123a85ffa21595f36"},
+		{"abcdbcdecdefdefgefghfghighijhijkijkljklmklmnlmnomnopnopq", "12a053384a9c0c88e405a06c27dcf49ada62eb2b"},
+		{strings.Repeat("a", 1000000), "52783243c1697bdbe16d37f97f68f08325dc1528"},
+	})
+}
+
+// check_streaming writes data in random pieces and checks the digest is the
+// same as hashing it in one go, and that Sum does not disturb the state.
+func check_streaming(t *testing.T, name string, new_hash func() hash.Hash) {
+	t.Helper()
+	r := rand.New(rand.NewSource(7))
+	data := make([]byte, 1000)
+	r.Read(data)
+	one := new_hash()
+	one.Write(data)
+	want := one.Sum(nil)
+	d := new_hash()
+	for i := 0; i < 20; i++ {
+		d.Reset()
+		for rest := data; len(rest) > 0; {
+			n := r.Intn(len(rest) + 1)
+			d.Write(rest[:n])
+			d.Sum(nil)
+			rest = rest[n:]
+		}
+		if got := d.Sum([]byte("prefix")); !bytes.Equal(got[6:], want) || string(got[:6]) != "prefix" {
+			t.Fatalf("%v: streamed digest %x, want %x", name, got[6:], want)
+		}
+	}
+	if len(want) != d.Size() {
+		t.Errorf("%v: Size() = %v, digest has %v bytes", name, d.Size(), len(want))
+	}
+}
+
+func TestStreaming(t *testing.T) {
+	check_streaming(t, "sha256", NewSHA256)
+	check_streaming(t, "ripemd160", NewRIPEMD160)
+}
+
+// TestSHA256Words checks digests in which some words start with zero
+// bytes, which must still be 32 bytes long, and that hashing a slice does
+// not write into its spare capacity.
+func TestSHA256Words(t *testing.T) {
+	zero_bytes := 0
+	for i := 0; i < 2000; i++ {
+		b := []byte{byte(i), byte(i >> 8)}
+		got := sha256(b)
+		want := stdsha256.Sum256(b)
+		if !bytes.Equal(got, want[:]) {
+			t.Fatalf("sha256(%x) = %x, want %x", b, got, want)
+		}
+		for w := 0; w < 32; w += 4 {
+			if got[w] == 0 {
+				zero_bytes++
+			}
+		}
+	}
+	if zero_bytes == 0 {
+		t.Fatal("no digest had a word starting with a zero byte")
+	}
+	buf := make([]byte, 3, 128)
+	copy(buf, "abc")
+	first := sha256(buf)
+	if !bytes.Equal(buf[3:cap(buf)], make([]byte, 125)) {
+		t.Fatal("sha256 wrote past the end of its input")
+	}
+	if !bytes.Equal(sha256(buf), first) {
+		t.Fatal("sha256 is not repeatable")
+	}
+}
+
+func TestSHA512(t *testing.T) {
+	check_vectors(t, "sha512", sha512, []hash_vector{
+		{"abc", "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f"},
+	})
+	r := rand.New(rand.NewSource(8))
+	for n := 0; n < 300; n++ {
+		b := make([]byte, n)
+		r.Read(b)
+		want := stdsha512.Sum512(b)
+		if got := sha512(b); !bytes.Equal(got, want[:]) {
+			t.Fatalf("sha512 of %v bytes = %x, want %x", n, got, want)
+		}
+	}
+	check_streaming(t, "sha512", NewSHA512)
+}
+
+// Test cases 1, 2 and 6 of RFC 4231, the last with a key longer than the
+// block size.
+func TestHMAC(t *testing.T) {
+	vectors := []struct {
+		key, message          []byte
+		want_sha256, want_512 string
+	}{
+		{
+			bytes.Repeat([]byte{0x0b}, 20), []byte("Hi There"),
+			"b0344c61d8db38535ca8afceaf0bf12b881dc200c9833da726e9376c2e32cff7",
+			"87aa7cdea5ef619d4ff0b4241a1d6cb02379f4e2ce4ec2787ad0b30545e17cdedaa833b7d6b8a702038b274eaea3f4e4be9d914eeb61f1702e696c203a126854",
+		},
+		{
+			[]byte("Jefe"), []byte("what do ya want for nothing?"),
+			"5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843",
+			"164b7a7bfcf819e2e395fbe73b56e0a387bd64222e831fd610270cd7ea2505549758bf75c05a994a6d034f65f8f0e6fdcaeab1a34d4a6b4b636e070a38bce737",
+		},
+		{
+			bytes.Repeat([]byte{0xaa}, 131), []byte("Test Using Larger Than Block-Size Key - Hash Key First"),
+			"60e431591ee0b67f0d8a26aacbf5b77f8e0bc6213728c5140546040f0ee37f54",
+			"80b24263c7c1a3ebb71493c1dd7be8b49b46d1f41b4aeec1121b013783f8f3526b56d037e05f2598bd0fd2215d6a1e5295e64f73f63f0aec8b915a985d786598",
+		},
+	}
+	for i, v := range vectors {
+		if got := hex.EncodeToString(hmac_sha256(v.key, v.message)); got != v.want_sha256 {
+			t.Errorf("case %v: hmac_sha256 = %v", i, got)
+		}
+		if got := hex.EncodeToString(hmac_sha512(v.key, v.message)); got != v.want_512 {
+			t.Errorf("case %v: hmac_sha512 = %v", i, got)
+		}
+	}
+	// Keys of every length around the block sizes.
+	message := []byte("message")
+	for n := 0; n < 200; n++ {
+		key := bytes.Repeat([]byte{byte(n)}, n)
+		m := stdhmac.New(stdsha256.New, key)
+		m.Write(message)
+		if got := hmac_sha256(key, message); !bytes.Equal(got, m.Sum(nil)) {
+			t.Fatalf("hmac_sha256 with a %v byte key", n)
+		}
+		m = stdhmac.New(stdsha512.New, key)
+		m.Write(message)
+		if got := hmac_sha512(key, message); !bytes.Equal(got, m.Sum(nil)) {
+			t.Fatalf("hmac_sha512 with a %v byte key", n)
+		}
+	}
+}
+
+func TestTaggedHash(t *testing.T) {
+	tag := stdsha256.Sum256([]byte("BIP0340/challenge"))
+	message := []byte("message")
+	want := stdsha256.Sum256(append(append(tag[:], tag[:]...), message...))
+	if got := tagged_hash("BIP0340/challenge", message); !bytes.Equal(got, want[:]) {
+		t.Errorf("tagged_hash = %x, want %x", got, want)
+	}
+	if bytes.Equal(tagged_hash("BIP0340/aux", message), tagged_hash("BIP0340/nonce", message)) {
+		t.Error("different tags give the same hash")
+	}
+}
+
+func TestHash256(t *testing.T) {
+	header, _ := hex.DecodeString("0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d1dac2b7c")
+	h := Hash256(header)
+	const genesis = "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+	if h.String() != genesis {
+		t.Fatalf("genesis block hash %v", h)
+	}
+	if h[31] != 0 || h[0] != 0x6f {
+		t.Error("Hash is not stored in digest order")
+	}
+	parsed, err := ParseHash(genesis)
+	if err != nil || parsed != h {
+		t.Errorf("ParseHash(%v) = %v, %v", genesis, parsed, err)
+	}
+	for _, bad := range []string{genesis[2:], genesis + "00", "zz" + genesis[2:]} {
+		if _, err := ParseHash(bad); err == nil {
+			t.Errorf("ParseHash(%v) did not fail", bad)
+		}
+	}
+}
+
+func TestHash160(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	// The compressed public key of secret key 1.
+	got := Hash160(PublicKey{*gen.G}.encode(true, false))
+	if hex.EncodeToString(got) != "751e76e8199196d454941c45d1b3a323f1433bd6" {
+		t.Errorf("Hash160 = %x", got)
+	}
+}
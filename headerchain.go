@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+type header_entry struct {
+	header    BlockHeader
+	hash      Hash
+	height    int
+	chainwork *big.Int // work of the chain up to and including this header
+	parent    *header_entry
+}
+
+// HeaderChain keeps block headers, checked for linkage and proof of work,
+// and follows the branch with the most work as SPV clients do. Headers on
+// other branches are kept too, so a branch that overtakes the active one
+// becomes active (a reorg).
+type HeaderChain struct {
+	params  ChainParams
+	entries map[Hash]*header_entry
+	active  []*header_entry // by height, the genesis first
+}
+
+// median_time_span is how many blocks the median time past is taken over.
+const median_time_span = 11
+
+func NewHeaderChain(net string) (*HeaderChain, error) {
+	params, err := NetParams(net)
+	if err != nil {
+		return nil, err
+	}
+	genesis, err := GenesisBlock(net)
+	if err != nil {
+		return nil, err
+	}
+	root := &header_entry{
+		header:    genesis.header,
+		hash:      genesis.BlockHash(),
+		chainwork: BlockWork(genesis.header.bits),
+	}
+	return &HeaderChain{
+		params:  params,
+		entries: map[Hash]*header_entry{root.hash: root},
+		active:  []*header_entry{root},
+	}, nil
+}
+
+func (c *HeaderChain) on_active(e *header_entry) bool {
+	return e.height < len(c.active) && c.active[e.height] == e
+}
+
+// ancestor returns the entry at height on e's branch.
+func (c *HeaderChain) ancestor(e *header_entry, height int) *header_entry {
+	for e.height > height && !c.on_active(e) {
+		e = e.parent
+	}
+	if c.on_active(e) {
+		return c.active[height]
+	}
+	return e
+}
+
+// median_time_past is the median timestamp of e and the blocks before it,
+// eleven at most. A new header's timestamp must be above it.
+func (c *HeaderChain) median_time_past(e *header_entry) uint32 {
+	var times []uint32
+	for ; e != nil && len(times) < median_time_span; e = e.parent {
+		times = append(times, e.header.timestamp)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2]
+}
+
+// AddHeader checks a header and adds it. Headers already known are
+// ignored; headers whose parent is not known are errors, so headers must
+// be added parents first.
+func (c *HeaderChain) AddHeader(h BlockHeader) error {
+	hash := h.BlockHash()
+	if _, ok := c.entries[hash]; ok {
+		return nil
+	}
+	parent, ok := c.entries[h.prev_block]
+	if !ok {
+		return fmt.Errorf("header %s: parent %s is not known", hash, h.prev_block)
+	}
+	if err := h.CheckProofOfWork(c.params); err != nil {
+		return fmt.Errorf("header %s: %v", hash, err)
+	}
+	ancestor := func(height int) BlockHeader {
+		return c.ancestor(parent, height).header
+	}
+	if bits := NextWorkRequired(parent.height, parent.header, h.timestamp, ancestor, c.params); h.bits != bits {
+		return fmt.Errorf("header %s: bits are %#08x, should be %#08x", hash, h.bits, bits)
+	}
+	if mtp := c.median_time_past(parent); h.timestamp <= mtp {
+		return fmt.Errorf("header %s: timestamp %v is not after the median time past %v", hash, h.timestamp, mtp)
+	}
+	e := &header_entry{
+		header:    h,
+		hash:      hash,
+		height:    parent.height + 1,
+		chainwork: new(big.Int).Add(parent.chainwork, BlockWork(h.bits)),
+		parent:    parent,
+	}
+	c.entries[hash] = e
+	if e.chainwork.Cmp(c.active[len(c.active)-1].chainwork) > 0 {
+		c.set_tip(e)
+	}
+	return nil
+}
+
+// AddHeaders adds headers in order, stopping at the first error.
+func (c *HeaderChain) AddHeaders(headers []BlockHeader) error {
+	for _, h := range headers {
+		if err := c.AddHeader(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set_tip makes e the tip, replacing the active chain from where e's branch
+// leaves it.
+func (c *HeaderChain) set_tip(e *header_entry) {
+	var branch []*header_entry
+	for ; !c.on_active(e); e = e.parent {
+		branch = append(branch, e)
+	}
+	c.active = c.active[:e.height+1]
+	for i := len(branch) - 1; i >= 0; i-- {
+		c.active = append(c.active, branch[i])
+	}
+}
+
+// Height is the height of the tip, 0 with only the genesis block.
+func (c *HeaderChain) Height() int {
+	return len(c.active) - 1
+}
+
+func (c *HeaderChain) Tip() Hash {
+	return c.active[len(c.active)-1].hash
+}
+
+// ChainWork is the total work of the active chain.
+func (c *HeaderChain) ChainWork() *big.Int {
+	return new(big.Int).Set(c.active[len(c.active)-1].chainwork)
+}
+
+// HashAt returns the hash of the active chain's block at height.
+func (c *HeaderChain) HashAt(height int) (Hash, bool) {
+	if height < 0 || height >= len(c.active) {
+		return Hash{}, false
+	}
+	return c.active[height].hash, true
+}
+
+// Header returns a known header, on the active chain or not.
+func (c *HeaderChain) Header(hash Hash) (BlockHeader, bool) {
+	e, ok := c.entries[hash]
+	if !ok {
+		return BlockHeader{}, false
+	}
+	return e.header, true
+}
+
+// HeightOf returns the height of a block on the active chain.
+func (c *HeaderChain) HeightOf(hash Hash) (int, bool) {
+	e, ok := c.entries[hash]
+	if !ok || !c.on_active(e) {
+		return 0, false
+	}
+	return e.height, true
+}
+
+// VerifyTx checks, SPV style, that the transaction txid is in the block
+// with hash block_hash on the active chain, which has tx_count transactions,
+// and returns its number of confirmations.
+func (c *HeaderChain) VerifyTx(txid Hash, proof MerkleProof, block_hash Hash, tx_count int) (int, error) {
+	height, ok := c.HeightOf(block_hash)
+	if !ok {
+		return 0, fmt.Errorf("block %s is not on the active chain", block_hash)
+	}
+	if !VerifyMerkleProof(txid, proof, c.active[height].header.merkle_root, tx_count) {
+		return 0, fmt.Errorf("merkle proof does not link %s to block %s", txid, block_hash)
+	}
+	return c.Height() - height + 1, nil
+}
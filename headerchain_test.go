@@ -0,0 +1,118 @@
+package main
+
+import "testing"
+
+// mine_header grinds the nonce until h meets its target. Regtest targets
+// take a couple of tries.
+func mine_header(h BlockHeader, params ChainParams) BlockHeader {
+	for h.CheckProofOfWork(params) != nil {
+		h.nonce++
+	}
+	return h
+}
+
+// extend_headers mines n regtest headers on prev, ten minutes apart. salt
+// goes in the merkle root so that forks from the same block differ.
+func extend_headers(prev BlockHeader, n int, salt byte, params ChainParams) []BlockHeader {
+	var headers []BlockHeader
+	for i := 0; i < n; i++ {
+		h := BlockHeader{version: 4, prev_block: prev.BlockHash(), timestamp: prev.timestamp + 600, bits: 0x207fffff, merkle_root: Hash{salt}}
+		prev = mine_header(h, params)
+		headers = append(headers, prev)
+	}
+	return headers
+}
+
+func TestHeaderChainReorg(t *testing.T) {
+	c, err := NewHeaderChain("regtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, _ := GenesisBlock("regtest")
+	a := extend_headers(g.header, 5, 1, c.params)
+	if err := c.AddHeaders(a); err != nil {
+		t.Fatal(err)
+	}
+	if c.Height() != 5 || c.Tip() != a[4].BlockHash() {
+		t.Fatalf("tip is %v at height %v", c.Tip(), c.Height())
+	}
+	// A fork from height 2 only takes over once it has more work.
+	b := extend_headers(a[1], 4, 2, c.params)
+	if err := c.AddHeaders(b[:3]); err != nil {
+		t.Fatal(err)
+	}
+	if c.Tip() != a[4].BlockHash() {
+		t.Fatal("reorged to a fork with equal work")
+	}
+	if _, ok := c.HeightOf(b[0].BlockHash()); ok {
+		t.Fatal("a header off the active chain has a height")
+	}
+	if err := c.AddHeader(b[3]); err != nil {
+		t.Fatal(err)
+	}
+	if c.Height() != 6 || c.Tip() != b[3].BlockHash() {
+		t.Fatalf("tip is %v at height %v after the fork took over", c.Tip(), c.Height())
+	}
+	if h, _ := c.HashAt(3); h != b[0].BlockHash() {
+		t.Error("height 3 is not on the fork")
+	}
+	if h, _ := c.HashAt(2); h != a[1].BlockHash() {
+		t.Error("height 2 is not the fork point")
+	}
+	if _, ok := c.Header(a[4].BlockHash()); !ok {
+		t.Error("forgot the stale tip")
+	}
+}
+
+func TestHeaderChainRejects(t *testing.T) {
+	c, _ := NewHeaderChain("regtest")
+	g, _ := GenesisBlock("regtest")
+	headers := extend_headers(g.header, 3, 1, c.params)
+	if err := c.AddHeaders(headers); err != nil {
+		t.Fatal(err)
+	}
+	tip := headers[2]
+	orphan := BlockHeader{version: 4, prev_block: Hash{9}, timestamp: tip.timestamp + 600, bits: 0x207fffff}
+	if err := c.AddHeader(mine_header(orphan, c.params)); err == nil {
+		t.Error("accepted a header with an unknown parent")
+	}
+	wrong_bits := BlockHeader{version: 4, prev_block: tip.BlockHash(), timestamp: tip.timestamp + 600, bits: 0x1d00ffff}
+	if err := c.AddHeader(wrong_bits); err == nil {
+		t.Error("accepted a header with the wrong target")
+	}
+	too_old := BlockHeader{version: 4, prev_block: tip.BlockHash(), timestamp: g.header.timestamp, bits: 0x207fffff}
+	if err := c.AddHeader(mine_header(too_old, c.params)); err == nil {
+		t.Error("accepted a header older than the median time past")
+	}
+	if c.Tip() != tip.BlockHash() {
+		t.Error("a rejected header moved the tip")
+	}
+}
+
+func TestVerifyTx(t *testing.T) {
+	c, _ := NewHeaderChain("regtest")
+	g, _ := GenesisBlock("regtest")
+	tx := Tx{version: 2, tx_ins: []TxIn{NewTxIn(Hash{3}, 0)}, tx_outs: []TxOut{{1, P2WPKH(make([]byte, 20))}}}
+	ids := []Hash{{7}, tx.TxID(), {8}}
+	block := mine_header(BlockHeader{version: 4, prev_block: g.header.BlockHash(), timestamp: g.header.timestamp + 600, bits: 0x207fffff, merkle_root: MerkleRoot(ids)}, c.params)
+	if err := c.AddHeader(block); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddHeaders(extend_headers(block, 2, 3, c.params)); err != nil {
+		t.Fatal(err)
+	}
+	proof, _ := NewMerkleProof(ids, 1)
+	confirmations, err := c.VerifyTx(tx.TxID(), proof, block.BlockHash(), len(ids))
+	if err != nil || confirmations != 3 {
+		t.Fatalf("%v confirmations: %v", confirmations, err)
+	}
+	if _, err := c.VerifyTx(Hash{8}, proof, block.BlockHash(), len(ids)); err == nil {
+		t.Error("proof of one txid verified another")
+	}
+	if _, err := c.VerifyTx(tx.TxID(), proof, block.BlockHash(), 5); err == nil {
+		t.Error("proof verified against the wrong transaction count")
+	}
+	if _, err := c.VerifyTx(tx.TxID(), proof, Hash{1}, len(ids)); err == nil {
+		t.Error("proof verified against an unknown block")
+	}
+}
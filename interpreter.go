@@ -0,0 +1,817 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Opcodes the interpreter knows about beyond those used by the templates.
+const (
+	OP_NOP                 = 0x61
+	OP_IF                  = 0x63
+	OP_NOTIF               = 0x64
+	OP_ELSE                = 0x67
+	OP_ENDIF               = 0x68
+	OP_VERIFY              = 0x69
+	OP_TOALTSTACK          = 0x6b
+	OP_FROMALTSTACK        = 0x6c
+	OP_2DROP               = 0x6d
+	OP_2DUP                = 0x6e
+	OP_3DUP                = 0x6f
+	OP_2OVER               = 0x70
+	OP_2ROT                = 0x71
+	OP_2SWAP               = 0x72
+	OP_IFDUP               = 0x73
+	OP_DEPTH               = 0x74
+	OP_DROP                = 0x75
+	OP_NIP                 = 0x77
+	OP_OVER                = 0x78
+	OP_PICK                = 0x79
+	OP_ROLL                = 0x7a
+	OP_ROT                 = 0x7b
+	OP_SWAP                = 0x7c
+	OP_TUCK                = 0x7d
+	OP_SIZE                = 0x82
+	OP_1ADD                = 0x8b
+	OP_1SUB                = 0x8c
+	OP_NEGATE              = 0x8f
+	OP_ABS                 = 0x90
+	OP_NOT                 = 0x91
+	OP_0NOTEQUAL           = 0x92
+	OP_ADD                 = 0x93
+	OP_SUB                 = 0x94
+	OP_BOOLAND             = 0x9a
+	OP_BOOLOR              = 0x9b
+	OP_NUMEQUAL            = 0x9c
+	OP_NUMEQUALVERIFY      = 0x9d
+	OP_NUMNOTEQUAL         = 0x9e
+	OP_LESSTHAN            = 0x9f
+	OP_GREATERTHAN         = 0xa0
+	OP_LESSTHANOREQUAL     = 0xa1
+	OP_GREATERTHANOREQUAL  = 0xa2
+	OP_MIN                 = 0xa3
+	OP_MAX                 = 0xa4
+	OP_WITHIN              = 0xa5
+	OP_RIPEMD160           = 0xa6
+	OP_SHA256              = 0xa8
+	OP_HASH256             = 0xaa
+	OP_CHECKSIGVERIFY      = 0xad
+	OP_CHECKMULTISIGVERIFY = 0xaf
+	OP_NOP1                = 0xb0
+	OP_CHECKLOCKTIMEVERIFY = 0xb1
+	OP_CHECKSEQUENCEVERIFY = 0xb2
+	OP_NOP10               = 0xb9
+)
+
+const (
+	max_element_size = 520
+	max_stack_size   = 1000
+)
+
+type sig_version int
+
+const (
+	sig_legacy sig_version = iota
+	sig_witness_v0
+)
+
+// script_engine runs scripts for one input of a transaction. The stack is
+// kept between calls to execute, which is how the scriptSig hands its
+// results to the scriptPubKey.
+type script_engine struct {
+	tx      Tx
+	index   int
+	gen     Generator
+	version sig_version
+	code    []byte // the script being executed, which signatures commit to
+	stack   [][]byte
+	alt     [][]byte
+}
+
+func (e *script_engine) push(b []byte) {
+	e.stack = append(e.stack, b)
+}
+
+func (e *script_engine) pop() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, fmt.Errorf("script: stack is empty")
+	}
+	top := e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+	return top, nil
+}
+
+// peek returns the i-th item from the top of the stack, 0 being the top.
+func (e *script_engine) peek(i int) ([]byte, error) {
+	if i < 0 || i >= len(e.stack) {
+		return nil, fmt.Errorf("script: stack has %v items, needed %v", len(e.stack), i+1)
+	}
+	return e.stack[len(e.stack)-1-i], nil
+}
+
+func (e *script_engine) pop_num() (int64, error) {
+	b, err := e.pop()
+	if err != nil {
+		return 0, err
+	}
+	return decode_num(b, 4)
+}
+
+func (e *script_engine) pop_bool() (bool, error) {
+	b, err := e.pop()
+	return cast_to_bool(b), err
+}
+
+// success reports whether the script left a true value on top of the stack.
+func (e *script_engine) success() bool {
+	top, err := e.peek(0)
+	return err == nil && cast_to_bool(top)
+}
+
+func cast_to_bool(b []byte) bool {
+	for i, c := range b {
+		if c != 0 {
+			// negative zero is false too
+			return !(i == len(b)-1 && c == 0x80)
+		}
+	}
+	return false
+}
+
+func encode_bool(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return []byte{}
+}
+
+// decode_num reads a script number: little endian, with the sign in the
+// top bit of the last byte.
+func decode_num(b []byte, max_len int) (int64, error) {
+	if len(b) > max_len {
+		return 0, fmt.Errorf("script: number is %v bytes long, at most %v allowed", len(b), max_len)
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	var n int64
+	for i, c := range b {
+		n |= int64(c) << (8 * i)
+	}
+	if b[len(b)-1]&0x80 != 0 {
+		return -(n &^ (int64(0x80) << (8 * (len(b) - 1)))), nil
+	}
+	return n, nil
+}
+
+func encode_num(n int64) []byte {
+	if n == 0 {
+		return []byte{}
+	}
+	neg := n < 0
+	if neg {
+		n = -n
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append(b, byte(n))
+	}
+	if b[len(b)-1]&0x80 != 0 {
+		if neg {
+			b = append(b, 0x80)
+		} else {
+			b = append(b, 0x00)
+		}
+	} else if neg {
+		b[len(b)-1] |= 0x80
+	}
+	return b
+}
+
+func (e *script_engine) execute(script []byte) error {
+	ops, err := parse_script(script)
+	if err != nil {
+		return err
+	}
+	e.code = script
+	// exec holds one entry per open OP_IF, telling whether its branch runs.
+	var exec []bool
+	for _, op := range ops {
+		executing := true
+		for _, x := range exec {
+			executing = executing && x
+		}
+		if len(op.data) > max_element_size {
+			return fmt.Errorf("script: push of %v bytes is larger than %v", len(op.data), max_element_size)
+		}
+		switch {
+		case op.opcode == OP_IF || op.opcode == OP_NOTIF:
+			v := false
+			if executing {
+				if v, err = e.pop_bool(); err != nil {
+					return err
+				}
+				if op.opcode == OP_NOTIF {
+					v = !v
+				}
+			}
+			exec = append(exec, v)
+		case op.opcode == OP_ELSE:
+			if len(exec) == 0 {
+				return fmt.Errorf("script: OP_ELSE without OP_IF")
+			}
+			exec[len(exec)-1] = !exec[len(exec)-1]
+		case op.opcode == OP_ENDIF:
+			if len(exec) == 0 {
+				return fmt.Errorf("script: OP_ENDIF without OP_IF")
+			}
+			exec = exec[:len(exec)-1]
+		case !executing:
+		case op.is_push():
+			e.push(op.data)
+		default:
+			if err := e.step(op.opcode); err != nil {
+				return err
+			}
+		}
+		if len(e.stack)+len(e.alt) > max_stack_size {
+			return fmt.Errorf("script: more than %v stack items", max_stack_size)
+		}
+	}
+	if len(exec) != 0 {
+		return fmt.Errorf("script: OP_IF without OP_ENDIF")
+	}
+	return nil
+}
+
+// step executes a single opcode that is not a push or a flow control opcode.
+func (e *script_engine) step(opcode byte) error {
+	s := e.stack
+	need := func(n int) error {
+		if len(e.stack) < n {
+			return fmt.Errorf("script: opcode %#x needs %v stack items, there are %v", opcode, n, len(e.stack))
+		}
+		return nil
+	}
+	switch {
+	case opcode == OP_1NEGATE:
+		e.push(encode_num(-1))
+	case opcode >= OP_1 && opcode <= OP_16:
+		e.push(encode_num(int64(opcode-OP_1) + 1))
+	case opcode == OP_CHECKLOCKTIMEVERIFY:
+		return e.check_locktime()
+	case opcode == OP_CHECKSEQUENCEVERIFY:
+		return e.check_sequence()
+	case opcode == OP_NOP || (opcode >= OP_NOP1 && opcode <= OP_NOP10):
+		// OP_NOP1 and OP_NOP4..OP_NOP10 are reserved for soft forks.
+	case opcode == OP_VERIFY:
+		v, err := e.pop_bool()
+		if err != nil {
+			return err
+		}
+		if !v {
+			return fmt.Errorf("script: OP_VERIFY failed")
+		}
+	case opcode == OP_RETURN:
+		return fmt.Errorf("script: OP_RETURN")
+	case opcode == OP_TOALTSTACK:
+		top, err := e.pop()
+		if err != nil {
+			return err
+		}
+		e.alt = append(e.alt, top)
+	case opcode == OP_FROMALTSTACK:
+		if len(e.alt) == 0 {
+			return fmt.Errorf("script: alt stack is empty")
+		}
+		e.push(e.alt[len(e.alt)-1])
+		e.alt = e.alt[:len(e.alt)-1]
+	case opcode == OP_2DROP:
+		if err := need(2); err != nil {
+			return err
+		}
+		e.stack = s[:len(s)-2]
+	case opcode == OP_2DUP:
+		if err := need(2); err != nil {
+			return err
+		}
+		e.stack = append(s, s[len(s)-2], s[len(s)-1])
+	case opcode == OP_3DUP:
+		if err := need(3); err != nil {
+			return err
+		}
+		e.stack = append(s, s[len(s)-3], s[len(s)-2], s[len(s)-1])
+	case opcode == OP_2OVER:
+		if err := need(4); err != nil {
+			return err
+		}
+		e.stack = append(s, s[len(s)-4], s[len(s)-3])
+	case opcode == OP_2ROT:
+		if err := need(6); err != nil {
+			return err
+		}
+		a, b := s[len(s)-6], s[len(s)-5]
+		e.stack = append(append(s[:len(s)-6:len(s)-6], s[len(s)-4:]...), a, b)
+	case opcode == OP_2SWAP:
+		if err := need(4); err != nil {
+			return err
+		}
+		n := len(s)
+		s[n-4], s[n-3], s[n-2], s[n-1] = s[n-2], s[n-1], s[n-4], s[n-3]
+	case opcode == OP_IFDUP:
+		top, err := e.peek(0)
+		if err != nil {
+			return err
+		}
+		if cast_to_bool(top) {
+			e.push(top)
+		}
+	case opcode == OP_DEPTH:
+		e.push(encode_num(int64(len(s))))
+	case opcode == OP_DROP:
+		if _, err := e.pop(); err != nil {
+			return err
+		}
+	case opcode == OP_DUP:
+		top, err := e.peek(0)
+		if err != nil {
+			return err
+		}
+		e.push(top)
+	case opcode == OP_NIP:
+		if err := need(2); err != nil {
+			return err
+		}
+		e.stack = append(s[:len(s)-2], s[len(s)-1])
+	case opcode == OP_OVER:
+		b, err := e.peek(1)
+		if err != nil {
+			return err
+		}
+		e.push(b)
+	case opcode == OP_PICK || opcode == OP_ROLL:
+		n, err := e.pop_num()
+		if err != nil {
+			return err
+		}
+		if n < 0 || n >= int64(len(e.stack)) {
+			return fmt.Errorf("script: OP_PICK/OP_ROLL index %v out of range", n)
+		}
+		i := len(e.stack) - 1 - int(n)
+		b := e.stack[i]
+		if opcode == OP_ROLL {
+			e.stack = append(e.stack[:i], e.stack[i+1:]...)
+		}
+		e.push(b)
+	case opcode == OP_ROT:
+		if err := need(3); err != nil {
+			return err
+		}
+		n := len(s)
+		s[n-3], s[n-2], s[n-1] = s[n-2], s[n-1], s[n-3]
+	case opcode == OP_SWAP:
+		if err := need(2); err != nil {
+			return err
+		}
+		n := len(s)
+		s[n-2], s[n-1] = s[n-1], s[n-2]
+	case opcode == OP_TUCK:
+		if err := need(2); err != nil {
+			return err
+		}
+		n := len(s)
+		top := s[n-1]
+		e.stack = append(s[:n-2:n-2], top, s[n-2], top)
+	case opcode == OP_SIZE:
+		top, err := e.peek(0)
+		if err != nil {
+			return err
+		}
+		e.push(encode_num(int64(len(top))))
+	case opcode == OP_EQUAL || opcode == OP_EQUALVERIFY:
+		if err := need(2); err != nil {
+			return err
+		}
+		a, _ := e.pop()
+		b, _ := e.pop()
+		if opcode == OP_EQUALVERIFY {
+			if !bytes.Equal(a, b) {
+				return fmt.Errorf("script: OP_EQUALVERIFY failed")
+			}
+			return nil
+		}
+		e.push(encode_bool(bytes.Equal(a, b)))
+	case opcode >= OP_1ADD && opcode <= OP_0NOTEQUAL:
+		a, err := e.pop_num()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case OP_1ADD:
+			a++
+		case OP_1SUB:
+			a--
+		case OP_NEGATE:
+			a = -a
+		case OP_ABS:
+			if a < 0 {
+				a = -a
+			}
+		case OP_NOT:
+			a = bool_num(a == 0)
+		case OP_0NOTEQUAL:
+			a = bool_num(a != 0)
+		default:
+			return fmt.Errorf("script: disabled opcode %#x", opcode)
+		}
+		e.push(encode_num(a))
+	case opcode >= OP_ADD && opcode <= OP_MAX:
+		b, err := e.pop_num()
+		if err != nil {
+			return err
+		}
+		a, err := e.pop_num()
+		if err != nil {
+			return err
+		}
+		var r int64
+		switch opcode {
+		case OP_ADD:
+			r = a + b
+		case OP_SUB:
+			r = a - b
+		case OP_BOOLAND:
+			r = bool_num(a != 0 && b != 0)
+		case OP_BOOLOR:
+			r = bool_num(a != 0 || b != 0)
+		case OP_NUMEQUAL, OP_NUMEQUALVERIFY:
+			r = bool_num(a == b)
+		case OP_NUMNOTEQUAL:
+			r = bool_num(a != b)
+		case OP_LESSTHAN:
+			r = bool_num(a < b)
+		case OP_GREATERTHAN:
+			r = bool_num(a > b)
+		case OP_LESSTHANOREQUAL:
+			r = bool_num(a <= b)
+		case OP_GREATERTHANOREQUAL:
+			r = bool_num(a >= b)
+		case OP_MIN:
+			r = a
+			if b < a {
+				r = b
+			}
+		case OP_MAX:
+			r = a
+			if b > a {
+				r = b
+			}
+		default:
+			return fmt.Errorf("script: disabled opcode %#x", opcode)
+		}
+		if opcode == OP_NUMEQUALVERIFY {
+			if r == 0 {
+				return fmt.Errorf("script: OP_NUMEQUALVERIFY failed")
+			}
+			return nil
+		}
+		e.push(encode_num(r))
+	case opcode == OP_WITHIN:
+		hi, err := e.pop_num()
+		if err != nil {
+			return err
+		}
+		lo, err := e.pop_num()
+		if err != nil {
+			return err
+		}
+		x, err := e.pop_num()
+		if err != nil {
+			return err
+		}
+		e.push(encode_bool(lo <= x && x < hi))
+	case opcode == OP_RIPEMD160 || opcode == OP_SHA256 || opcode == OP_HASH160 || opcode == OP_HASH256:
+		top, err := e.pop()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case OP_RIPEMD160:
+			e.push(ripemd160(top))
+		case OP_SHA256:
+			e.push(sha256(top))
+		case OP_HASH160:
+			e.push(Hash160(top))
+		case OP_HASH256:
+			h := Hash256(top)
+			e.push(h[:])
+		}
+	case opcode == OP_CHECKSIG || opcode == OP_CHECKSIGVERIFY:
+		if err := need(2); err != nil {
+			return err
+		}
+		pubkey, _ := e.pop()
+		sig, _ := e.pop()
+		ok := e.check_sig(sig, pubkey)
+		if opcode == OP_CHECKSIGVERIFY {
+			if !ok {
+				return fmt.Errorf("script: OP_CHECKSIGVERIFY failed")
+			}
+			return nil
+		}
+		e.push(encode_bool(ok))
+	case opcode == OP_CHECKMULTISIG || opcode == OP_CHECKMULTISIGVERIFY:
+		ok, err := e.check_multisig()
+		if err != nil {
+			return err
+		}
+		if opcode == OP_CHECKMULTISIGVERIFY {
+			if !ok {
+				return fmt.Errorf("script: OP_CHECKMULTISIGVERIFY failed")
+			}
+			return nil
+		}
+		e.push(encode_bool(ok))
+	default:
+		return fmt.Errorf("script: unknown or disabled opcode %#x", opcode)
+	}
+	return nil
+}
+
+func bool_num(v bool) int64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// check_sig verifies a signature, with its sighash byte, against the script
+// being executed. Malformed signatures and keys just fail the check.
+func (e *script_engine) check_sig(sig, pubkey []byte) bool {
+	if len(sig) == 0 || sig[len(sig)-1] != SIGHASH_ALL {
+		return false
+	}
+	s, err := sig_decode(sig[:len(sig)-1], e.gen)
+	if err != nil {
+		return false
+	}
+	pub, err := decode_pubkey(e.gen.G.curve, pubkey)
+	if err != nil {
+		return false
+	}
+	var message []byte
+	if e.version == sig_witness_v0 {
+		message = e.tx.bip143_message(e.index, e.code)
+	} else {
+		message = e.tx.legacy_message(e.index, e.code)
+	}
+	return verify(pub.Point, e.gen, message, s)
+}
+
+// check_locktime implements OP_CHECKLOCKTIMEVERIFY (BIP65): the spending
+// transaction must not be final before the locktime on top of the stack,
+// which is left there.
+func (e *script_engine) check_locktime() error {
+	top, err := e.peek(0)
+	if err != nil {
+		return err
+	}
+	// 5 byte numbers, so that timestamps up to 2^39-1 fit.
+	n, err := decode_num(top, 5)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return fmt.Errorf("script: negative locktime")
+	}
+	locktime := int64(e.tx.locktime)
+	if (n < locktime_threshold) != (locktime < locktime_threshold) {
+		return fmt.Errorf("script: locktime %v and transaction locktime %v are not of the same kind", n, locktime)
+	}
+	if n > locktime {
+		return fmt.Errorf("script: locktime %v not reached, transaction locktime is %v", n, locktime)
+	}
+	// A final input would let the transaction in regardless of its locktime.
+	if e.tx.tx_ins[e.index].sequence == SEQUENCE_FINAL {
+		return fmt.Errorf("script: OP_CHECKLOCKTIMEVERIFY on an input with a final sequence")
+	}
+	return nil
+}
+
+// check_sequence implements OP_CHECKSEQUENCEVERIFY (BIP112): the input's
+// BIP68 relative lock must be at least the one on top of the stack.
+func (e *script_engine) check_sequence() error {
+	top, err := e.peek(0)
+	if err != nil {
+		return err
+	}
+	n, err := decode_num(top, 5)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return fmt.Errorf("script: negative sequence")
+	}
+	if n&sequence_disable_flag != 0 {
+		return nil
+	}
+	if e.tx.version < 2 {
+		return fmt.Errorf("script: OP_CHECKSEQUENCEVERIFY needs transaction version 2")
+	}
+	sequence := int64(e.tx.tx_ins[e.index].sequence)
+	if sequence&sequence_disable_flag != 0 {
+		return fmt.Errorf("script: relative lock time is disabled on the input")
+	}
+	mask := int64(sequence_type_flag | sequence_mask)
+	n, sequence = n&mask, sequence&mask
+	if (n&sequence_type_flag != 0) != (sequence&sequence_type_flag != 0) {
+		return fmt.Errorf("script: relative lock of blocks compared with one of time")
+	}
+	if n > sequence {
+		return fmt.Errorf("script: relative lock %#x not reached, input sequence is %#x", n, sequence)
+	}
+	return nil
+}
+
+// check_multisig pops <dummy> <sig>... m <pubkey>... n and checks that the
+// signatures match m of the keys, in the same order. The dummy element is
+// there because OP_CHECKMULTISIG has always popped one item too many; it
+// must be empty (BIP147).
+func (e *script_engine) check_multisig() (bool, error) {
+	n, err := e.pop_num()
+	if err != nil {
+		return false, err
+	}
+	if n < 0 || n > 20 || int(n) > len(e.stack) {
+		return false, fmt.Errorf("script: invalid OP_CHECKMULTISIG key count %v", n)
+	}
+	pubkeys := make([][]byte, n)
+	for i := int(n) - 1; i >= 0; i-- {
+		pubkeys[i], _ = e.pop()
+	}
+	m, err := e.pop_num()
+	if err != nil {
+		return false, err
+	}
+	if m < 0 || m > n || int(m) > len(e.stack) {
+		return false, fmt.Errorf("script: invalid OP_CHECKMULTISIG signature count %v", m)
+	}
+	sigs := make([][]byte, m)
+	for i := int(m) - 1; i >= 0; i-- {
+		sigs[i], _ = e.pop()
+	}
+	dummy, err := e.pop()
+	if err != nil {
+		return false, err
+	}
+	if len(dummy) != 0 {
+		return false, fmt.Errorf("script: OP_CHECKMULTISIG dummy element is not empty")
+	}
+	k := 0
+	for _, sig := range sigs {
+		for k < len(pubkeys) && !e.check_sig(sig, pubkeys[k]) {
+			k++
+		}
+		if k == len(pubkeys) {
+			return false, nil
+		}
+		k++
+	}
+	return true, nil
+}
+
+// parse_witness_program recognises a segwit scriptPubKey: a version opcode
+// followed by a single push of 2 to 40 bytes.
+func parse_witness_program(script []byte) (int, []byte, bool) {
+	if len(script) < 4 || len(script) > 42 || int(script[1]) != len(script)-2 {
+		return 0, nil, false
+	}
+	op := ScriptOp{opcode: script[0]}
+	version, ok := op.small_int()
+	if !ok {
+		return 0, nil, false
+	}
+	return version, script[2:], true
+}
+
+func is_push_only(script []byte) bool {
+	ops, err := parse_script(script)
+	if err != nil {
+		return false
+	}
+	for _, op := range ops {
+		if !op.is_push() {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyInput checks that input index is allowed to spend its output: the
+// scriptSig and the scriptPubKey, then the redeem script of a P2SH output
+// (BIP16), then the witness of a segwit output, native or wrapped in P2SH.
+func (t Tx) VerifyInput(index int, gen Generator) error {
+	if index < 0 || index >= len(t.tx_ins) {
+		return fmt.Errorf("no input %v", index)
+	}
+	in := t.tx_ins[index]
+	if in.prev_tx_script_pubkey == nil {
+		return fmt.Errorf("input %v: scriptPubKey of the spent output is not known", index)
+	}
+	var script_sig []byte
+	if in.script_sig != nil {
+		script_sig = script_body(in.script_sig)
+	}
+	script_pubkey := script_body(in.prev_tx_script_pubkey)
+	e := &script_engine{tx: t, index: index, gen: gen}
+	if err := e.execute(script_sig); err != nil {
+		return fmt.Errorf("input %v: scriptSig: %v", index, err)
+	}
+	p2sh_stack := append([][]byte(nil), e.stack...)
+	if err := e.execute(script_pubkey); err != nil {
+		return fmt.Errorf("input %v: scriptPubKey: %v", index, err)
+	}
+	if !e.success() {
+		return fmt.Errorf("input %v: scriptPubKey evaluated to false", index)
+	}
+	witness_checked := false
+	if version, program, ok := parse_witness_program(script_pubkey); ok {
+		if len(script_sig) != 0 {
+			return fmt.Errorf("input %v: native segwit spends must have an empty scriptSig", index)
+		}
+		if err := t.verify_witness(index, gen, version, program); err != nil {
+			return fmt.Errorf("input %v: %v", index, err)
+		}
+		witness_checked = true
+	} else if Classify(ByteScript{cmds: script_pubkey}).Class == ScriptHashClass {
+		if !is_push_only(script_sig) {
+			return fmt.Errorf("input %v: P2SH scriptSig must only push data", index)
+		}
+		// Run the redeem script, the last item the scriptSig pushed, on the
+		// rest of what the scriptSig pushed.
+		redeem := p2sh_stack[len(p2sh_stack)-1]
+		e.stack = p2sh_stack[:len(p2sh_stack)-1]
+		e.alt = nil
+		if err := e.execute(redeem); err != nil {
+			return fmt.Errorf("input %v: redeem script: %v", index, err)
+		}
+		if !e.success() {
+			return fmt.Errorf("input %v: redeem script evaluated to false", index)
+		}
+		if version, program, ok := parse_witness_program(redeem); ok {
+			if !bytes.Equal(script_sig, push_data(redeem)) {
+				return fmt.Errorf("input %v: P2SH-wrapped segwit scriptSig must be a single push of the redeem script", index)
+			}
+			if err := t.verify_witness(index, gen, version, program); err != nil {
+				return fmt.Errorf("input %v: %v", index, err)
+			}
+			witness_checked = true
+		}
+	}
+	if !witness_checked && len(in.witness) != 0 {
+		return fmt.Errorf("input %v: unexpected witness", index)
+	}
+	return nil
+}
+
+func (t Tx) verify_witness(index int, gen Generator, version int, program []byte) error {
+	witness := t.tx_ins[index].witness
+	var script []byte
+	switch {
+	case version == 0 && len(program) == 20:
+		if len(witness) != 2 {
+			return fmt.Errorf("P2WPKH witness must have 2 items, has %v", len(witness))
+		}
+		script = P2PKH(program).cmds
+	case version == 0 && len(program) == 32:
+		if len(witness) == 0 {
+			return fmt.Errorf("P2WSH witness is empty")
+		}
+		script = witness[len(witness)-1]
+		witness = witness[:len(witness)-1]
+		if !bytes.Equal(sha256(script), program) {
+			return fmt.Errorf("witness script does not match the witness program")
+		}
+	case version == 0:
+		return fmt.Errorf("witness program of %v bytes", len(program))
+	case version == 1 && len(program) == 32:
+		return fmt.Errorf("taproot spending is not supported")
+	default:
+		// Unknown witness versions are left for future soft forks to define.
+		return nil
+	}
+	for _, item := range witness {
+		if len(item) > max_element_size {
+			return fmt.Errorf("witness item of %v bytes is larger than %v", len(item), max_element_size)
+		}
+	}
+	e := &script_engine{tx: t, index: index, gen: gen, version: sig_witness_v0}
+	e.stack = append([][]byte(nil), witness...)
+	if err := e.execute(script); err != nil {
+		return fmt.Errorf("witness script: %v", err)
+	}
+	if len(e.stack) != 1 || !e.success() {
+		return fmt.Errorf("witness script must leave a single true item on the stack")
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func sigpush(gen Generator, k *big.Int, msg []byte) []byte {
+	return append(test_sign(k, gen, msg).sig_encode(), SIGHASH_ALL)
+}
+
+func TestSpends(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	var keys []*big.Int
+	var pubs [][]byte
+	for i := 1; i <= 3; i++ {
+		k := big.NewInt(int64(1000 + i))
+		keys = append(keys, k)
+		pubs = append(pubs, PublicKey{gen.base_multiply(k)}.encode(true, false))
+	}
+	var h Hash
+	h[0] = 7
+	spending := func(spk Script) Tx {
+		in := NewTxIn(h, 0)
+		in.prev_tx_script_pubkey = spk
+		in.prev_amount = 100000
+		return Tx{version: 2, tx_ins: []TxIn{in}, tx_outs: []TxOut{{90000, P2PKH(Hash160(pubs[0]))}}}
+	}
+	multisig_sig := func(msg []byte, signers ...int) []byte {
+		ss := []byte{OP_0}
+		for _, i := range signers {
+			ss = append(ss, push_data(sigpush(gen, keys[i], msg))...)
+		}
+		return ss
+	}
+
+	// P2SH 2-of-3
+	ms, _ := Multisig(2, pubs)
+	tx := spending(P2SH(Hash160(ms.cmds)))
+	tx.tx_ins[0].redeem_script = ms
+	msg, err := tx.SigMessage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.tx_ins[0].script_sig = ByteScript{cmds: append(multisig_sig(msg, 0, 2), push_data(ms.cmds)...)}
+	if err := tx.VerifyInput(0, gen); err != nil {
+		t.Fatal("p2sh multisig: ", err)
+	}
+	// Signatures must come in the order of the keys.
+	tx.tx_ins[0].script_sig = ByteScript{cmds: append(multisig_sig(msg, 2, 0), push_data(ms.cmds)...)}
+	if err := tx.VerifyInput(0, gen); err == nil {
+		t.Fatal("p2sh multisig: signatures out of order accepted")
+	}
+	// A redeem script that does not match the hash.
+	other, _ := Multisig(1, pubs)
+	tx.tx_ins[0].script_sig = ByteScript{cmds: append(multisig_sig(msg, 0), push_data(other.cmds)...)}
+	if err := tx.VerifyInput(0, gen); err == nil {
+		t.Fatal("p2sh multisig: wrong redeem script accepted")
+	}
+
+	// P2SH-P2WPKH
+	redeem := P2WPKH(Hash160(pubs[1]))
+	tx = spending(P2SH(Hash160(redeem.cmds)))
+	tx.tx_ins[0].redeem_script = redeem
+	msg, err = tx.SigMessage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.tx_ins[0].script_sig = ByteScript{cmds: push_data(redeem.cmds)}
+	tx.tx_ins[0].witness = [][]byte{sigpush(gen, keys[1], msg), pubs[1]}
+	if err := tx.VerifyInput(0, gen); err != nil {
+		t.Fatal("p2sh-p2wpkh: ", err)
+	}
+	// Segwit signatures commit to the amount.
+	tx.tx_ins[0].prev_amount = 100001
+	if err := tx.VerifyInput(0, gen); err == nil {
+		t.Fatal("p2sh-p2wpkh: amount not committed")
+	}
+
+	// P2SH-P2WSH 2-of-3
+	redeem = P2WSH(sha256(ms.cmds))
+	tx = spending(P2SH(Hash160(redeem.cmds)))
+	tx.tx_ins[0].redeem_script = redeem
+	tx.tx_ins[0].witness_script = ms
+	msg, err = tx.SigMessage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.tx_ins[0].script_sig = ByteScript{cmds: push_data(redeem.cmds)}
+	tx.tx_ins[0].witness = [][]byte{{}, sigpush(gen, keys[0], msg), sigpush(gen, keys[1], msg), ms.cmds}
+	if err := tx.VerifyInput(0, gen); err != nil {
+		t.Fatal("p2sh-p2wsh: ", err)
+	}
+
+	// P2WPKH
+	tx = spending(P2WPKH(Hash160(pubs[2])))
+	msg, _ = tx.SigMessage(0)
+	tx.tx_ins[0].witness = [][]byte{sigpush(gen, keys[2], msg), pubs[2]}
+	if err := tx.VerifyInput(0, gen); err != nil {
+		t.Fatal("p2wpkh: ", err)
+	}
+	tx.tx_ins[0].witness = [][]byte{sigpush(gen, keys[1], msg), pubs[2]}
+	if err := tx.VerifyInput(0, gen); err == nil {
+		t.Fatal("p2wpkh: signature by another key accepted")
+	}
+
+	// P2PKH
+	tx = spending(P2PKH(Hash160(pubs[2])))
+	msg, _ = tx.SigMessage(0)
+	tx.tx_ins[0].script_sig = ByteScript{cmds: append(push_data(sigpush(gen, keys[2], msg)), push_data(pubs[2])...)}
+	if err := tx.VerifyInput(0, gen); err != nil {
+		t.Fatal("p2pkh: ", err)
+	}
+	tx.tx_ins[0].witness = [][]byte{{1}}
+	if err := tx.VerifyInput(0, gen); err == nil {
+		t.Fatal("p2pkh: unexpected witness accepted")
+	}
+}
+
+func TestScriptNumbers(t *testing.T) {
+	for _, n := range []int64{0, 1, -1, 127, 128, -128, 255, 256, -255, 32767, -32768, 1 << 30, -(1 << 31) + 1} {
+		m, err := decode_num(encode_num(n), 4)
+		if err != nil || m != n {
+			t.Fatalf("%v decodes to %v, %v", n, m, err)
+		}
+	}
+	if _, err := decode_num([]byte{1, 2, 3, 4, 5}, 4); err == nil {
+		t.Error("decoded a 5 byte number")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+// jacobian is a point in Jacobian coordinates, (X, Y, Z) standing for the
+// affine point (X/Z^2, Y/Z^3). Additions and doublings need no field
+// inversion, so long chains of them are much cheaper than with Point. Z = 0
+// encodes the point at infinity.
+type jacobian struct {
+	curve Curve
+	x     FieldElement
+	y     FieldElement
+	z     FieldElement
+}
+
+func (c Curve) jacobian_inf() jacobian {
+	zero := FieldElement{mod: c.fp}
+	one := FieldElement{mod: c.fp, v: c.fp.one}
+	return jacobian{curve: c, x: one, y: one, z: zero}
+}
+
+func (p Point) to_jacobian() jacobian {
+	if p.inf {
+		return p.curve.jacobian_inf()
+	}
+	return jacobian{
+		curve: p.curve,
+		x:     p.x,
+		y:     p.y,
+		z:     FieldElement{mod: p.curve.fp, v: p.curve.fp.one},
+	}
+}
+
+func (j jacobian) is_inf() bool {
+	return j.z.IsZero()
+}
+
+func (j jacobian) to_affine() Point {
+	if j.is_inf() {
+		return j.curve.Infinity()
+	}
+	zinv := j.z.Inverse()
+	zinv2 := zinv.Square()
+	return Point{
+		curve: j.curve,
+		x:     j.x.Mul(zinv2),
+		y:     j.y.Mul(zinv2).Mul(zinv),
+	}
+}
+
+func (j jacobian) double() jacobian {
+	if j.is_inf() || j.y.IsZero() {
+		return j.curve.jacobian_inf()
+	}
+	xx := j.x.Square()
+	yy := j.y.Square()
+	yyyy := yy.Square()
+	zz := j.z.Square()
+	s := j.x.Add(yy).Square().Sub(xx).Sub(yyyy)
+	s = s.Add(s)
+	m := xx.Add(xx).Add(xx).Add(j.curve.a.Mul(zz.Square()))
+	t := m.Square().Sub(s).Sub(s)
+	y8 := yyyy.Add(yyyy)
+	y8 = y8.Add(y8)
+	y8 = y8.Add(y8)
+	return jacobian{
+		curve: j.curve,
+		x:     t,
+		y:     m.Mul(s.Sub(t)).Sub(y8),
+		z:     j.y.Add(j.z).Square().Sub(yy).Sub(zz),
+	}
+}
+
+// add_affine adds an affine point to j (the "mixed" addition).
+func (j jacobian) add_affine(p Point) jacobian {
+	if p.inf {
+		return j
+	}
+	if j.is_inf() {
+		return p.to_jacobian()
+	}
+	z1z1 := j.z.Square()
+	u2 := p.x.Mul(z1z1)
+	s2 := p.y.Mul(j.z).Mul(z1z1)
+	h := u2.Sub(j.x)
+	r := s2.Sub(j.y)
+	if h.IsZero() {
+		if r.IsZero() {
+			return j.double()
+		}
+		return j.curve.jacobian_inf()
+	}
+	r = r.Add(r)
+	hh := h.Square()
+	i := hh.Add(hh)
+	i = i.Add(i)
+	jj := h.Mul(i)
+	v := j.x.Mul(i)
+	x3 := r.Square().Sub(jj).Sub(v).Sub(v)
+	y1j := j.y.Mul(jj)
+	return jacobian{
+		curve: j.curve,
+		x:     x3,
+		y:     r.Mul(v.Sub(x3)).Sub(y1j).Sub(y1j),
+		z:     j.z.Add(h).Square().Sub(z1z1).Sub(hh),
+	}
+}
+
+func (j jacobian) add(o jacobian) jacobian {
+	if o.is_inf() {
+		return j
+	}
+	if j.is_inf() {
+		return o
+	}
+	z1z1 := j.z.Square()
+	z2z2 := o.z.Square()
+	u1 := j.x.Mul(z2z2)
+	u2 := o.x.Mul(z1z1)
+	s1 := j.y.Mul(o.z).Mul(z2z2)
+	s2 := o.y.Mul(j.z).Mul(z1z1)
+	h := u2.Sub(u1)
+	r := s2.Sub(s1)
+	if h.IsZero() {
+		if r.IsZero() {
+			return j.double()
+		}
+		return j.curve.jacobian_inf()
+	}
+	r = r.Add(r)
+	i := h.Add(h).Square()
+	jj := h.Mul(i)
+	v := u1.Mul(i)
+	x3 := r.Square().Sub(jj).Sub(v).Sub(v)
+	s1j := s1.Mul(jj)
+	return jacobian{
+		curve: j.curve,
+		x:     x3,
+		y:     r.Mul(v.Sub(x3)).Sub(s1j).Sub(s1j),
+		z:     j.z.Add(o.z).Square().Sub(z1z1).Sub(z2z2).Mul(h),
+	}
+}
+
+func (j jacobian) neg() jacobian {
+	j.y = j.y.Neg()
+	return j
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+// scaled returns p in Jacobian coordinates with z instead of 1, so that
+// the formulas see a Z other than one.
+func scaled(p Point, z *big.Int) jacobian {
+	fz := NewFieldElement(p.curve.fp, z)
+	zz := fz.Square()
+	return jacobian{curve: p.curve, x: p.x.Mul(zz), y: p.y.Mul(zz).Mul(fz), z: fz}
+}
+
+// random_nonzero returns a random integer in [1, m).
+func random_nonzero(r *rand.Rand, m *big.Int) *big.Int {
+	one := big.NewInt(1)
+	k := random_below(r, new(big.Int).Sub(m, one))
+	return k.Add(k, one)
+}
+
+// TestJacobianDifferential checks the Jacobian formulas against affine
+// addition, on a curve with a = 0 and on one without.
+func TestJacobianDifferential(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for _, name := range []string{"secp256k1", "secp256r1"} {
+		gen, _ := LookupCurve(name)
+		curve := gen.G.curve
+		for i := 0; i < 100; i++ {
+			p := gen.base_multiply(random_nonzero(r, gen.n))
+			q := gen.base_multiply(random_nonzero(r, gen.n))
+			jp := scaled(p, random_nonzero(r, curve.fp.big))
+			jq := scaled(q, random_nonzero(r, curve.fp.big))
+			check := func(what string, got jacobian, want Point) {
+				t.Helper()
+				if !got.to_affine().Compare(want) {
+					t.Fatalf("%v: %v", name, what)
+				}
+			}
+			sum := p.elliptic_curve_addition(q)
+			check("add", jp.add(jq), sum)
+			check("add_affine", jp.add_affine(q), sum)
+			check("double", jp.double(), p.elliptic_curve_addition(p))
+			check("add itself", jp.add(jp), p.elliptic_curve_addition(p))
+			check("add_affine itself", jp.add_affine(p), p.elliptic_curve_addition(p))
+			check("add negation", jp.add(jp.neg()), curve.Infinity())
+			check("add infinity", jp.add(curve.jacobian_inf()), p)
+			check("add to infinity", curve.jacobian_inf().add_affine(p), p)
+			check("add_affine infinity", jp.add_affine(curve.Infinity()), p)
+		}
+		if !curve.jacobian_inf().double().is_inf() || !curve.Infinity().to_jacobian().to_affine().IsInfinity() {
+			t.Errorf("%v: infinity", name)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Locktimes below locktime_threshold are block heights, the others unix
+// timestamps.
+const locktime_threshold = 500000000
+
+// SEQUENCE_FINAL opts an input out of both the transaction's locktime and
+// BIP68 relative locks. NewTxIn uses it.
+const SEQUENCE_FINAL = 0xffffffff
+
+// BIP68 relative lock time encoding of a sequence number. With the disable
+// flag clear, the low 16 bits are a number of blocks, or with the type flag
+// set a number of 512 second units.
+const (
+	sequence_disable_flag = 1 << 31
+	sequence_type_flag    = 1 << 22
+	sequence_mask         = 0x0000ffff
+	sequence_granularity  = 9
+)
+
+// LockHeight returns the locktime of a transaction that can be mined from
+// block height on.
+func LockHeight(height uint32) (uint32, error) {
+	if height >= locktime_threshold {
+		return 0, fmt.Errorf("block height %v would be read as a timestamp", height)
+	}
+	return height, nil
+}
+
+// LockTime returns the locktime of a transaction that can be mined once the
+// median time of the last 11 blocks is past t.
+func LockTime(t time.Time) (uint32, error) {
+	u := t.Unix()
+	if u < locktime_threshold || u > 0xffffffff {
+		return 0, fmt.Errorf("time %v cannot be expressed as a locktime", t)
+	}
+	return uint32(u), nil
+}
+
+// RelativeBlocks returns the sequence of an input that can be mined once the
+// output it spends has blocks confirmations.
+func RelativeBlocks(blocks uint16) uint32 {
+	return uint32(blocks)
+}
+
+// RelativeTime returns the sequence of an input that can be mined d after
+// the output it spends was. d is rounded up to a multiple of 512 seconds.
+func RelativeTime(d time.Duration) (uint32, error) {
+	units := (int64(d/time.Second) + 1<<sequence_granularity - 1) >> sequence_granularity
+	if d < 0 || units > sequence_mask {
+		return 0, fmt.Errorf("relative lock of %v is out of range", d)
+	}
+	return sequence_type_flag | uint32(units), nil
+}
+
+// decode_sequence returns the relative lock an input's sequence sets: in
+// blocks, or in seconds if by_time is set. enabled is false when the
+// sequence does not set one.
+func decode_sequence(sequence uint32) (value uint32, by_time, enabled bool) {
+	if sequence&sequence_disable_flag != 0 {
+		return 0, false, false
+	}
+	value = sequence & sequence_mask
+	if sequence&sequence_type_flag != 0 {
+		return value << sequence_granularity, true, true
+	}
+	return value, false, true
+}
+
+// SetLockTime sets the locktime of the transaction. A locktime is only
+// enforced if some input is not final, so final inputs are changed to
+// 0xfffffffe, which still leaves relative locks disabled.
+func (t *Tx) SetLockTime(locktime uint32) {
+	t.locktime = locktime
+	for i := range t.tx_ins {
+		if t.tx_ins[i].sequence == SEQUENCE_FINAL {
+			t.tx_ins[i].sequence = SEQUENCE_FINAL - 1
+		}
+	}
+}
+
+// IsFinal reports whether the transaction can go in the block at height,
+// whose previous blocks have median time past median_time.
+func (t Tx) IsFinal(height, median_time uint32) bool {
+	if t.locktime == 0 {
+		return true
+	}
+	limit := height
+	if t.locktime >= locktime_threshold {
+		limit = median_time
+	}
+	if t.locktime < limit {
+		return true
+	}
+	for _, tx_in := range t.tx_ins {
+		if tx_in.sequence != SEQUENCE_FINAL {
+			return false
+		}
+	}
+	return true
+}
+
+// RelativeLockReached reports whether the BIP68 lock of input index allows
+// it to be mined, given how many blocks and seconds (of median time past)
+// have passed since the output it spends was confirmed.
+func (t Tx) RelativeLockReached(index int, blocks, seconds uint32) (bool, error) {
+	if index < 0 || index >= len(t.tx_ins) {
+		return false, fmt.Errorf("no input %v", index)
+	}
+	if t.version < 2 {
+		return true, nil
+	}
+	value, by_time, enabled := decode_sequence(t.tx_ins[index].sequence)
+	switch {
+	case !enabled:
+		return true, nil
+	case by_time:
+		return seconds >= value, nil
+	}
+	return blocks >= value, nil
+}
+
+// timelock_branch returns <lock> opcode OP_DROP <pubkey> OP_CHECKSIG.
+func timelock_branch(lock uint32, opcode byte, pubkey []byte) []byte {
+	cmds := push_num(int64(lock))
+	cmds = append(cmds, opcode, OP_DROP)
+	cmds = append(cmds, push_data(pubkey)...)
+	return append(cmds, OP_CHECKSIG)
+}
+
+// CLTVScript locks coins to pubkey until the absolute locktime.
+func CLTVScript(locktime uint32, pubkey []byte) ByteScript {
+	return ByteScript{cmds: timelock_branch(locktime, OP_CHECKLOCKTIMEVERIFY, pubkey)}
+}
+
+// CSVScript locks coins to pubkey until they are sequence old (BIP68).
+func CSVScript(sequence uint32, pubkey []byte) ByteScript {
+	return ByteScript{cmds: timelock_branch(sequence, OP_CHECKSEQUENCEVERIFY, pubkey)}
+}
+
+// Vault returns a script that owner can spend at any time and heir only
+// once the lock has passed:
+//
+//	OP_IF <owner> OP_CHECKSIG OP_ELSE <lock> OP_CSV|OP_CLTV OP_DROP <heir> OP_CHECKSIG OP_ENDIF
+//
+// With relative set, lock is a BIP68 sequence, so the heir's wait restarts
+// whenever the owner moves the coins to a fresh vault. Otherwise it is a
+// locktime, fixing the date the heir (or escrow agent) can take over.
+func Vault(owner, heir []byte, lock uint32, relative bool) ByteScript {
+	opcode := byte(OP_CHECKLOCKTIMEVERIFY)
+	if relative {
+		opcode = OP_CHECKSEQUENCEVERIFY
+	}
+	cmds := []byte{OP_IF}
+	cmds = append(cmds, push_data(owner)...)
+	cmds = append(cmds, OP_CHECKSIG, OP_ELSE)
+	cmds = append(cmds, timelock_branch(lock, opcode, heir)...)
+	return ByteScript{cmds: append(cmds, OP_ENDIF)}
+}
+
+// VaultWitness returns the stack that spends a Vault with sig, through the
+// owner's branch or the heir's. For P2WSH the vault script goes after it,
+// for P2SH the items are pushed in a scriptSig followed by the script.
+func VaultWitness(sig []byte, owner bool) [][]byte {
+	if owner {
+		return [][]byte{sig, {1}}
+	}
+	return [][]byte{sig, {}}
+}
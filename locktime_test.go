@@ -0,0 +1,140 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestLockEncoding(t *testing.T) {
+	if _, err := LockHeight(locktime_threshold); err == nil {
+		t.Error("LockHeight accepted a timestamp")
+	}
+	if _, err := LockTime(time.Unix(1000, 0)); err == nil {
+		t.Error("LockTime accepted a height")
+	}
+	if lt, err := LockTime(time.Unix(1700000000, 0)); err != nil || lt != 1700000000 {
+		t.Errorf("LockTime = %v, %v", lt, err)
+	}
+	seq, err := RelativeTime(3 * 24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rounded up to 512 second units.
+	if v, by_time, enabled := decode_sequence(seq); !enabled || !by_time || v != 507*512 {
+		t.Errorf("decode_sequence(%#x) = %v, %v, %v", seq, v, by_time, enabled)
+	}
+	if _, err := RelativeTime(time.Duration(sequence_mask+1) * 512 * time.Second); err == nil {
+		t.Error("RelativeTime accepted an overlong lock")
+	}
+	if v, by_time, enabled := decode_sequence(RelativeBlocks(144)); !enabled || by_time || v != 144 {
+		t.Errorf("RelativeBlocks(144) decodes to %v, %v, %v", v, by_time, enabled)
+	}
+	if _, _, enabled := decode_sequence(SEQUENCE_FINAL - 1); enabled {
+		t.Error("the disable flag is ignored")
+	}
+}
+
+func TestIsFinal(t *testing.T) {
+	tx := Tx{version: 1, tx_ins: []TxIn{NewTxIn(Hash{}, 0)}}
+	tx.SetLockTime(100)
+	if tx.tx_ins[0].sequence == SEQUENCE_FINAL {
+		t.Fatal("SetLockTime left the input final")
+	}
+	if tx.IsFinal(100, 0) || !tx.IsFinal(101, 0) {
+		t.Error("height locktime")
+	}
+	tx.SetLockTime(1700000000)
+	if tx.IsFinal(1000000, 1700000000) || !tx.IsFinal(0, 1700000001) {
+		t.Error("time locktime")
+	}
+	tx.tx_ins[0].sequence = SEQUENCE_FINAL
+	if !tx.IsFinal(0, 0) {
+		t.Error("final inputs disable the locktime")
+	}
+	tx = Tx{version: 2, tx_ins: []TxIn{NewTxIn(Hash{}, 0)}}
+	tx.tx_ins[0].sequence = RelativeBlocks(10)
+	if ok, _ := tx.RelativeLockReached(0, 9, 1<<30); ok {
+		t.Error("relative block lock reached early")
+	}
+	if ok, _ := tx.RelativeLockReached(0, 10, 0); !ok {
+		t.Error("relative block lock not reached")
+	}
+	for _, index := range []int{-1, 1} {
+		if _, err := tx.RelativeLockReached(index, 10, 0); err == nil {
+			t.Errorf("input %v accepted", index)
+		}
+	}
+	tx.version = 1
+	if ok, _ := tx.RelativeLockReached(0, 0, 0); !ok {
+		t.Error("BIP68 applies to version 1")
+	}
+}
+
+func TestVault(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	owner_key, heir_key := big.NewInt(111), big.NewInt(222)
+	owner := PublicKey{gen.base_multiply(owner_key)}.encode(true, false)
+	heir := PublicKey{gen.base_multiply(heir_key)}.encode(true, false)
+	seq, _ := RelativeTime(3 * 24 * time.Hour)
+	height, _ := LockHeight(800000)
+	for _, relative := range []bool{true, false} {
+		lock := height
+		if relative {
+			lock = seq
+		}
+		vault := Vault(owner, heir, lock, relative)
+		spend := func(version, sequence, locktime uint32, by_owner bool) error {
+			in := NewTxIn(Hash{}, 0)
+			in.prev_tx_script_pubkey = P2WSH(sha256(vault.cmds))
+			in.witness_script = vault
+			in.prev_amount = 1000
+			tx := Tx{version: version, tx_ins: []TxIn{in}, tx_outs: []TxOut{{900, P2WPKH(Hash160(owner))}}}
+			tx.SetLockTime(locktime)
+			if sequence != 0 {
+				tx.tx_ins[0].sequence = sequence
+			}
+			msg, err := tx.SigMessage(0)
+			if err != nil {
+				return err
+			}
+			key := heir_key
+			if by_owner {
+				key = owner_key
+			}
+			sig := append(test_sign(key, gen, msg).sig_encode(), SIGHASH_ALL)
+			tx.tx_ins[0].witness = append(VaultWitness(sig, by_owner), vault.cmds)
+			return tx.VerifyInput(0, gen)
+		}
+		if err := spend(1, 0, 0, true); err != nil {
+			t.Fatal("owner: ", err)
+		}
+		if relative {
+			if err := spend(2, seq, 0, false); err != nil {
+				t.Fatal("heir after the CSV lock: ", err)
+			}
+			if spend(2, seq-1, 0, false) == nil {
+				t.Error("heir before the CSV lock")
+			}
+			if spend(1, seq, 0, false) == nil {
+				t.Error("CSV in a version 1 transaction")
+			}
+			if spend(2, RelativeBlocks(1000), 0, false) == nil {
+				t.Error("CSV with a block lock for a time lock")
+			}
+		} else {
+			if err := spend(1, 0, 800000, false); err != nil {
+				t.Fatal("heir after the CLTV lock: ", err)
+			}
+			if spend(1, 0, 799999, false) == nil {
+				t.Error("heir before the CLTV lock")
+			}
+			if spend(1, SEQUENCE_FINAL, 800000, false) == nil {
+				t.Error("CLTV with a final input")
+			}
+			if spend(1, 0, 1700000000, false) == nil {
+				t.Error("CLTV with a timestamp for a height")
+			}
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// merkle_parent hashes two nodes of a merkle tree into their parent.
+func merkle_parent(left, right Hash) Hash {
+	return Hash256(append(left[:], right[:]...))
+}
+
+// merkle_level returns the level above hashes. A level with an odd number
+// of nodes pairs its last node with itself.
+func merkle_level(hashes []Hash) []Hash {
+	var parents []Hash
+	for i := 0; i < len(hashes); i += 2 {
+		right := hashes[i]
+		if i+1 < len(hashes) {
+			right = hashes[i+1]
+		}
+		parents = append(parents, merkle_parent(hashes[i], right))
+	}
+	return parents
+}
+
+// MerkleRoot is the root of the merkle tree over hashes, as blocks commit to
+// their txids. The root of a single hash is the hash itself.
+func MerkleRoot(hashes []Hash) Hash {
+	root, _ := merkle_root_mutated(hashes)
+	return root
+}
+
+// merkle_root_mutated also reports whether some level pairs two equal
+// nodes. Duplicating the last node of an odd level gives the same root, so
+// such a list of txids has the same root as a shorter one (CVE-2012-2459).
+func merkle_root_mutated(hashes []Hash) (Hash, bool) {
+	if len(hashes) == 0 {
+		return Hash{}, false
+	}
+	mutated := false
+	for len(hashes) > 1 {
+		for i := 0; i+1 < len(hashes); i += 2 {
+			mutated = mutated || hashes[i] == hashes[i+1]
+		}
+		hashes = merkle_level(hashes)
+	}
+	return hashes[0], mutated
+}
+
+// merkle_depth is the number of levels above the leaves in a tree of n.
+func merkle_depth(n int) int {
+	depth := 0
+	for ; n > 1; n = (n + 1) / 2 {
+		depth++
+	}
+	return depth
+}
+
+func (b Block) txids() []Hash {
+	var ids []Hash
+	for _, tx := range b.txs {
+		ids = append(ids, tx.TxID())
+	}
+	return ids
+}
+
+// MerkleRoot computes the root over the block's transactions, which must
+// match the one in its header.
+func (b Block) MerkleRoot() Hash {
+	return MerkleRoot(b.txids())
+}
+
+// Segwit blocks also commit to their wtxids (BIP141): the coinbase has an
+// output whose script is OP_RETURN, a push of 36 bytes, the header below and
+// Hash256 of the witness root and a 32 byte reserved value. The reserved
+// value is the only item of the coinbase's witness.
+var witness_commitment_header = []byte{OP_RETURN, 0x24, 0xaa, 0x21, 0xa9, 0xed}
+
+// WitnessRoot is the merkle root of the block's wtxids, with the coinbase's
+// taken to be zero since its witness holds the commitment's reserved value.
+func (b Block) WitnessRoot() Hash {
+	var ids []Hash
+	for i, tx := range b.txs {
+		if i == 0 {
+			ids = append(ids, Hash{})
+		} else {
+			ids = append(ids, tx.WTxID())
+		}
+	}
+	return MerkleRoot(ids)
+}
+
+// WitnessCommitment is what the coinbase commits to for a witness root.
+func WitnessCommitment(witness_root Hash, reserved []byte) Hash {
+	return Hash256(append(witness_root[:], reserved...))
+}
+
+// WitnessCommitmentScript is the scriptPubKey of the coinbase output holding
+// commitment.
+func WitnessCommitmentScript(commitment Hash) ByteScript {
+	return ByteScript{cmds: append(append([]byte(nil), witness_commitment_header...), commitment[:]...)}
+}
+
+// witness_commitment finds the commitment in the coinbase. If more than one
+// output has one, the last counts.
+func (b Block) witness_commitment() (Hash, bool) {
+	var commitment Hash
+	found := false
+	if len(b.txs) == 0 {
+		return commitment, false
+	}
+	for _, out := range b.txs[0].tx_outs {
+		script := script_body(out.script_pubkey)
+		if len(script) >= 38 && bytes.HasPrefix(script, witness_commitment_header) {
+			copy(commitment[:], script[len(witness_commitment_header):])
+			found = true
+		}
+	}
+	return commitment, found
+}
+
+// CheckMerkleRoots checks the header's merkle root and, if any transaction
+// has a witness, the coinbase's witness commitment.
+func (b Block) CheckMerkleRoots() error {
+	if len(b.txs) == 0 {
+		return fmt.Errorf("block has no transactions")
+	}
+	root, mutated := merkle_root_mutated(b.txids())
+	if root != b.header.merkle_root {
+		return fmt.Errorf("merkle root is %s, header has %s", root, b.header.merkle_root)
+	}
+	if mutated {
+		return fmt.Errorf("block has duplicate transactions in its merkle tree")
+	}
+	commitment, found := b.witness_commitment()
+	has_witness := false
+	for _, tx := range b.txs {
+		has_witness = has_witness || tx.has_witness()
+	}
+	if !found {
+		if has_witness {
+			return fmt.Errorf("block has witnesses but no witness commitment")
+		}
+		return nil
+	}
+	coinbase_witness := b.txs[0].tx_ins[0].witness
+	if len(coinbase_witness) != 1 || len(coinbase_witness[0]) != 32 {
+		return fmt.Errorf("coinbase witness must be a single 32 byte reserved value")
+	}
+	if want := WitnessCommitment(b.WitnessRoot(), coinbase_witness[0]); want != commitment {
+		return fmt.Errorf("witness commitment is %x, should be %x", commitment[:], want[:])
+	}
+	return nil
+}
+
+// MerkleProof shows that a leaf is in a merkle tree: the leaf's position and
+// the sibling of each node on its way up to the root.
+type MerkleProof struct {
+	Index    int
+	Siblings []Hash
+}
+
+// NewMerkleProof makes the proof that hashes[index] is under MerkleRoot(hashes).
+func NewMerkleProof(hashes []Hash, index int) (MerkleProof, error) {
+	if index < 0 || index >= len(hashes) {
+		return MerkleProof{}, fmt.Errorf("no leaf %v in a tree of %v", index, len(hashes))
+	}
+	proof := MerkleProof{Index: index}
+	for i := index; len(hashes) > 1; i /= 2 {
+		sibling := i ^ 1
+		if sibling >= len(hashes) {
+			sibling = i
+		}
+		proof.Siblings = append(proof.Siblings, hashes[sibling])
+		hashes = merkle_level(hashes)
+	}
+	return proof, nil
+}
+
+// Root is the merkle root leaf leads to along the proof.
+func (p MerkleProof) Root(leaf Hash) Hash {
+	node := leaf
+	for i, sibling := range p.Siblings {
+		if (p.Index>>i)&1 == 0 {
+			node = merkle_parent(node, sibling)
+		} else {
+			node = merkle_parent(sibling, node)
+		}
+	}
+	return node
+}
+
+// VerifyMerkleProof checks that leaf is under root in a tree of n leaves. A
+// light client takes the root from a block header whose proof of work it has
+// checked. Without n, a proof could stop at an inner node and pass off its
+// 64 bytes as a transaction.
+func VerifyMerkleProof(leaf Hash, proof MerkleProof, root Hash, n int) bool {
+	return proof.Index >= 0 && proof.Index < n && len(proof.Siblings) == merkle_depth(n) && proof.Root(leaf) == root
+}
+
+// TxProof makes the proof that the transaction with txid is in the block.
+func (b Block) TxProof(txid Hash) (MerkleProof, error) {
+	ids := b.txids()
+	for i, id := range ids {
+		if id == txid {
+			return NewMerkleProof(ids, i)
+		}
+	}
+	return MerkleProof{}, fmt.Errorf("transaction %s is not in block %s", txid, b.BlockHash())
+}
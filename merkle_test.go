@@ -0,0 +1,142 @@
+package main
+
+import "testing"
+
+// naive_root is the merkle root as the whitepaper describes it.
+func naive_root(hashes []Hash) Hash {
+	if len(hashes) == 1 {
+		return hashes[0]
+	}
+	if len(hashes)%2 == 1 {
+		hashes = append(hashes, hashes[len(hashes)-1])
+	}
+	var parents []Hash
+	for i := 0; i < len(hashes); i += 2 {
+		parents = append(parents, Hash256(append(append([]byte(nil), hashes[i][:]...), hashes[i+1][:]...)))
+	}
+	return naive_root(parents)
+}
+
+func test_leaves(n int) []Hash {
+	var hashes []Hash
+	for i := 0; i < n; i++ {
+		hashes = append(hashes, Hash256([]byte{byte(i)}))
+	}
+	return hashes
+}
+
+func TestMerkleRoot(t *testing.T) {
+	for n := 1; n <= 13; n++ {
+		hashes := test_leaves(n)
+		root := MerkleRoot(hashes)
+		if root != naive_root(hashes) {
+			t.Fatalf("root of %v leaves", n)
+		}
+		for i := range hashes {
+			proof, err := NewMerkleProof(hashes, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyMerkleProof(hashes[i], proof, root, n) {
+				t.Fatalf("proof of leaf %v of %v", i, n)
+			}
+			if n > 1 && VerifyMerkleProof(hashes[(i+1)%n], proof, root, n) {
+				t.Fatalf("proof of leaf %v of %v proves leaf %v", i, n, (i+1)%n)
+			}
+		}
+	}
+	if _, err := NewMerkleProof(test_leaves(3), 3); err == nil {
+		t.Error("proof of a leaf past the end")
+	}
+}
+
+func TestMerkleProofDepth(t *testing.T) {
+	hashes := test_leaves(4)
+	root := MerkleRoot(hashes)
+	proof, _ := NewMerkleProof(hashes, 2)
+	if VerifyMerkleProof(hashes[2], proof, root, 8) || VerifyMerkleProof(hashes[2], proof, root, 2) {
+		t.Error("proof checked against the wrong tree size")
+	}
+	// Stopping a level short proves the inner node over leaves 2 and 3.
+	inner := merkle_parent(hashes[2], hashes[3])
+	short := MerkleProof{Index: 1, Siblings: proof.Siblings[1:]}
+	if short.Root(inner) != root {
+		t.Fatal("short proof does not reach the root")
+	}
+	if VerifyMerkleProof(inner, short, root, 4) {
+		t.Error("an inner node passed for a leaf")
+	}
+	if VerifyMerkleProof(hashes[0], MerkleProof{Index: 4, Siblings: proof.Siblings}, root, 4) {
+		t.Error("proof of a leaf past the end")
+	}
+}
+
+func TestMerkleMutation(t *testing.T) {
+	g, _ := GenesisBlock("regtest")
+	tx := func(i byte) Tx {
+		return Tx{version: 2, tx_ins: []TxIn{NewTxIn(Hash{i}, 0)}, tx_outs: []TxOut{{5000, P2WPKH(make([]byte, 20))}}}
+	}
+	b := Block{header: g.header, txs: []Tx{g.txs[0], tx(1), tx(2)}}
+	b.header.merkle_root = b.MerkleRoot()
+	if err := b.CheckMerkleRoots(); err != nil {
+		t.Fatal(err)
+	}
+	// [a, b, c, c] has the same root as [a, b, c].
+	mutated := b
+	mutated.txs = append(append([]Tx(nil), b.txs...), b.txs[2])
+	if mutated.MerkleRoot() != b.header.merkle_root {
+		t.Fatal("duplicating the last transaction changed the root")
+	}
+	if err := mutated.CheckMerkleRoots(); err == nil {
+		t.Error("accepted a block with its last transaction duplicated")
+	}
+	// One level up, [a, b, c, d, e, f, e, f] has the root of [a, b, c, d, e, f]
+	// without any two txids in a pair being equal.
+	six := Block{header: g.header, txs: []Tx{g.txs[0], tx(1), tx(2), tx(3), tx(4), tx(5)}}
+	six.header.merkle_root = six.MerkleRoot()
+	six.txs = append(six.txs, six.txs[4], six.txs[5])
+	if six.MerkleRoot() != six.header.merkle_root {
+		t.Fatal("duplicating the last pair changed the root")
+	}
+	if err := six.CheckMerkleRoots(); err == nil {
+		t.Error("accepted a block with its last pair duplicated")
+	}
+}
+
+func TestWitnessCommitment(t *testing.T) {
+	g, _ := GenesisBlock("main")
+	if err := g.CheckMerkleRoots(); err != nil {
+		t.Fatal(err)
+	}
+	coinbase := g.txs[0]
+	coinbase.tx_ins = append([]TxIn(nil), coinbase.tx_ins...)
+	coinbase.tx_ins[0].witness = [][]byte{make([]byte, 32)}
+	spend := Tx{version: 2, tx_ins: []TxIn{NewTxIn(Hash{5}, 0)}, tx_outs: []TxOut{{5, P2WPKH(make([]byte, 20))}}}
+	spend.tx_ins[0].witness = [][]byte{{1, 2}, {3}}
+	b := Block{txs: []Tx{coinbase, spend}}
+	commitment := WitnessCommitment(b.WitnessRoot(), make([]byte, 32))
+	coinbase.tx_outs = append(append([]TxOut(nil), coinbase.tx_outs...), TxOut{0, WitnessCommitmentScript(commitment)})
+	b.txs[0] = coinbase
+	b.header.merkle_root = b.MerkleRoot()
+	if err := b.CheckMerkleRoots(); err != nil {
+		t.Fatal(err)
+	}
+	proof, err := b.TxProof(spend.TxID())
+	if err != nil || !VerifyMerkleProof(spend.TxID(), proof, b.header.merkle_root, len(b.txs)) {
+		t.Fatalf("proof of the spend: %v", err)
+	}
+	parsed, err := ParseBlock(b.BlockEncode())
+	if err != nil || parsed.BlockHash() != b.BlockHash() || len(parsed.txs) != 2 {
+		t.Fatalf("block round trip: %v", err)
+	}
+	// Changing a witness keeps the txid but breaks the commitment.
+	b.txs[1].tx_ins[0].witness = [][]byte{{1, 2}, {4}}
+	if err := b.CheckMerkleRoots(); err == nil {
+		t.Error("accepted a changed witness")
+	}
+	b.txs[1].tx_ins[0].witness = [][]byte{{1, 2}, {3}}
+	b.txs[0].tx_ins[0].witness = nil
+	if err := b.CheckMerkleRoots(); err == nil {
+		t.Error("accepted a coinbase without the reserved value")
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// MultisigKind says how the multisig script of a wallet is paid to.
+type MultisigKind int
+
+const (
+	MultisigP2SH MultisigKind = iota
+	MultisigP2WSH
+	MultisigP2SHP2WSH
+)
+
+var multisig_kind_names = [...]string{"p2sh", "p2wsh", "p2sh-p2wsh"}
+
+func (k MultisigKind) String() string {
+	if k < 0 || int(k) >= len(multisig_kind_names) {
+		return fmt.Sprintf("MultisigKind(%d)", int(k))
+	}
+	return multisig_kind_names[k]
+}
+
+// MultisigWallet is an m-of-n policy over a set of compressed public keys.
+// The keys are sorted as BIP67 requires, so every cosigner derives the same
+// script, and so the same address, whatever order they list the keys in.
+type MultisigWallet struct {
+	required int
+	pubkeys  [][]byte
+	script   ByteScript
+}
+
+func NewMultisigWallet(m int, keys []PublicKey) (MultisigWallet, error) {
+	pubkeys := make([][]byte, len(keys))
+	for i, k := range keys {
+		pubkeys[i] = k.encode(true, false)
+	}
+	sort.Slice(pubkeys, func(i, j int) bool {
+		return bytes.Compare(pubkeys[i], pubkeys[j]) < 0
+	})
+	for i := 1; i < len(pubkeys); i++ {
+		if bytes.Equal(pubkeys[i-1], pubkeys[i]) {
+			return MultisigWallet{}, fmt.Errorf("public key %x is listed twice", pubkeys[i])
+		}
+	}
+	script, err := Multisig(m, pubkeys)
+	if err != nil {
+		return MultisigWallet{}, err
+	}
+	return MultisigWallet{required: m, pubkeys: pubkeys, script: script}, nil
+}
+
+// Script is the redeem script (P2SH) or witness script (P2WSH, P2SH-P2WSH).
+func (w MultisigWallet) Script() ByteScript {
+	return w.script
+}
+
+func (w MultisigWallet) ScriptPubKey(kind MultisigKind) (ByteScript, error) {
+	switch kind {
+	case MultisigP2SH:
+		return P2SH(Hash160(w.script.cmds)), nil
+	case MultisigP2WSH:
+		return P2WSH(sha256(w.script.cmds)), nil
+	case MultisigP2SHP2WSH:
+		return P2SH(Hash160(P2WSH(sha256(w.script.cmds)).cmds)), nil
+	}
+	return ByteScript{}, fmt.Errorf("unknown multisig kind %v", kind)
+}
+
+func (w MultisigWallet) Address(net string, kind MultisigKind) (string, error) {
+	switch kind {
+	case MultisigP2SH:
+		return ScriptAddress(net, w.script)
+	case MultisigP2WSH:
+		return SegwitAddress(net, 0, sha256(w.script.cmds))
+	case MultisigP2SHP2WSH:
+		return ScriptAddress(net, P2WSH(sha256(w.script.cmds)))
+	}
+	return "", fmt.Errorf("unknown multisig kind %v", kind)
+}
+
+// PrepareInput fills in what signers need to know about an output of the
+// wallet that tx_in spends: its scriptPubKey, amount and scripts.
+func (w MultisigWallet) PrepareInput(tx_in *TxIn, kind MultisigKind, amount int64) error {
+	script_pubkey, err := w.ScriptPubKey(kind)
+	if err != nil {
+		return err
+	}
+	tx_in.prev_tx_script_pubkey = script_pubkey
+	tx_in.prev_amount = amount
+	switch kind {
+	case MultisigP2SH:
+		tx_in.redeem_script = w.script
+	case MultisigP2WSH:
+		tx_in.witness_script = w.script
+	case MultisigP2SHP2WSH:
+		tx_in.redeem_script = P2WSH(sha256(w.script.cmds))
+		tx_in.witness_script = w.script
+	}
+	return nil
+}
+
+// Sign returns one cosigner's partial signature, with its sighash byte, for
+// input index of tx. The input must have been set up with PrepareInput.
+func (w MultisigWallet) Sign(tx Tx, index int, secret_key *big.Int, gen Generator) ([]byte, error) {
+	pubkey := PublicKey{gen.base_multiply(secret_key)}.encode(true, false)
+	if w.key_index(pubkey) < 0 {
+		return nil, fmt.Errorf("key %x is not one of the wallet's keys", pubkey)
+	}
+	message, err := tx.SigMessage(index)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := ecdsa_sign(secret_key, gen, message)
+	if err != nil {
+		return nil, err
+	}
+	return append(sig.sig_encode(), SIGHASH_ALL), nil
+}
+
+func (w MultisigWallet) key_index(pubkey []byte) int {
+	for i, pk := range w.pubkeys {
+		if bytes.Equal(pk, pubkey) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Combine puts partial signatures, collected from the cosigners in any
+// order, into input index of tx. OP_CHECKMULTISIG wants them in the order of
+// the keys, after the extra dummy element it pops, so each signature is
+// matched to its key first. Signatures beyond the required number are left
+// out, and ones matching no key are an error.
+func (w MultisigWallet) Combine(tx *Tx, index int, kind MultisigKind, sigs [][]byte, gen Generator) error {
+	if kind < 0 || int(kind) >= len(multisig_kind_names) {
+		return fmt.Errorf("unknown multisig kind %v", kind)
+	}
+	code, segwit, err := tx.script_code(index)
+	if err != nil {
+		return err
+	}
+	e := &script_engine{tx: *tx, index: index, gen: gen, code: code}
+	if segwit {
+		e.version = sig_witness_v0
+	}
+	by_key := make([][]byte, len(w.pubkeys))
+	for _, sig := range sigs {
+		found := false
+		for i, pk := range w.pubkeys {
+			if e.check_sig(sig, pk) {
+				by_key[i] = sig
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("signature %x does not match any of the wallet's keys", sig)
+		}
+	}
+	stack := [][]byte{{}}
+	for _, sig := range by_key {
+		if sig != nil && len(stack) <= w.required {
+			stack = append(stack, sig)
+		}
+	}
+	if len(stack)-1 < w.required {
+		return fmt.Errorf("have %v of the %v signatures needed", len(stack)-1, w.required)
+	}
+	tx_in := &tx.tx_ins[index]
+	switch kind {
+	case MultisigP2SH:
+		var script_sig []byte
+		for _, item := range stack {
+			script_sig = append(script_sig, push_data(item)...)
+		}
+		tx_in.script_sig = ByteScript{cmds: append(script_sig, push_data(w.script.cmds)...)}
+		tx_in.witness = nil
+	case MultisigP2WSH:
+		tx_in.script_sig = nil
+		tx_in.witness = append(stack, w.script.cmds)
+	case MultisigP2SHP2WSH:
+		tx_in.script_sig = ByteScript{cmds: push_data(P2WSH(sha256(w.script.cmds)).cmds)}
+		tx_in.witness = append(stack, w.script.cmds)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestMultisigWallet(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	var keys []*big.Int
+	var pubs []PublicKey
+	for i := 1; i <= 3; i++ {
+		k := big.NewInt(int64(5000 + 7*i))
+		keys = append(keys, k)
+		pubs = append(pubs, PublicKey{gen.base_multiply(k)})
+	}
+	w, err := NewMultisigWallet(2, pubs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// BIP67: the order the keys are given in does not matter.
+	shuffled, _ := NewMultisigWallet(2, []PublicKey{pubs[2], pubs[0], pubs[1]})
+	for _, kind := range []MultisigKind{MultisigP2SH, MultisigP2WSH, MultisigP2SHP2WSH} {
+		a1, err := w.Address("test", kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a2, _ := shuffled.Address("test", kind); a1 != a2 {
+			t.Fatalf("%v: addresses %v and %v for the same keys", kind, a1, a2)
+		}
+		want, _ := w.ScriptPubKey(kind)
+		if script, err := ParseAddress("test", a1); err != nil || Classify(script).Class != Classify(want).Class {
+			t.Fatalf("%v: address %v does not parse back", kind, a1)
+		}
+		var h Hash
+		h[3] = 9
+		in := NewTxIn(h, 1)
+		if err := w.PrepareInput(&in, kind, 50000); err != nil {
+			t.Fatal(err)
+		}
+		tx := Tx{version: 2, tx_ins: []TxIn{in}, tx_outs: []TxOut{{40000, P2WPKH(pubs[0].encode(true, true))}}}
+		s2, err := w.Sign(tx, 0, keys[2], gen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s0, _ := w.Sign(tx, 0, keys[0], gen)
+		if err := w.Combine(&tx, 0, kind, [][]byte{s2}, gen); err == nil {
+			t.Fatalf("%v: combined a single signature", kind)
+		}
+		if err := w.Combine(&tx, 0, kind, [][]byte{s2, s0}, gen); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.VerifyInput(0, gen); err != nil {
+			t.Fatal(kind, err)
+		}
+	}
+	if _, err := w.Sign(Tx{}, 0, big.NewInt(3), gen); err == nil {
+		t.Fatal("signed with a key not in the wallet")
+	}
+	if _, err := NewMultisigWallet(2, []PublicKey{pubs[0], pubs[0]}); err == nil {
+		t.Fatal("accepted a key listed twice")
+	}
+	if _, err := w.Address("test", MultisigKind(7)); err == nil {
+		t.Fatal("unknown kind")
+	}
+	if _, err := w.ScriptPubKey(MultisigKind(7)); err == nil {
+		t.Fatal("scriptPubKey for an unknown kind")
+	}
+	var in TxIn
+	if err := w.PrepareInput(&in, MultisigKind(7), 50000); err == nil {
+		t.Fatal("prepared an input of an unknown kind")
+	}
+	if err := w.Combine(&Tx{}, 0, MultisigKind(-1), nil, gen); err == nil {
+		t.Fatal("combined an input of an unknown kind")
+	}
+	if got := MultisigKind(7).String(); got != "MultisigKind(7)" {
+		t.Errorf("MultisigKind(7).String() = %v", got)
+	}
+}
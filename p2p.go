@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// The peer to peer protocol. Every message travels in an envelope: the
+// network's magic bytes, a command name padded to 12 bytes, the payload's
+// length and the first 4 bytes of its Hash256, then the payload.
+
+const (
+	envelope_size = 24
+	// max_payload_size is Bitcoin Core's limit on a message.
+	max_payload_size = 4000000
+	protocol_version = 70016
+
+	max_inv_items    = 50000
+	max_headers      = 2000
+	max_addresses    = 1000
+	max_locator_size = 101
+)
+
+var net_magic = map[string][4]byte{
+	"main":    {0xf9, 0xbe, 0xb4, 0xd9},
+	"test":    {0x0b, 0x11, 0x09, 0x07},
+	"regtest": {0xfa, 0xbf, 0xb5, 0xda},
+}
+
+// Message is one of the messages below. Command names the message and
+// Payload serializes it.
+type Message interface {
+	Command() string
+	Payload() []byte
+}
+
+// EncodeMessage wraps msg in the envelope of net.
+func EncodeMessage(net string, msg Message) ([]byte, error) {
+	magic, ok := net_magic[net]
+	if !ok {
+		return nil, fmt.Errorf("unknown network %q", net)
+	}
+	command := msg.Command()
+	if len(command) > 12 {
+		return nil, fmt.Errorf("command %q is longer than 12 bytes", command)
+	}
+	payload := msg.Payload()
+	if len(payload) > max_payload_size {
+		return nil, fmt.Errorf("%v payload of %v bytes is too large", command, len(payload))
+	}
+	out := append([]byte(nil), magic[:]...)
+	name := make([]byte, 12)
+	copy(name, command)
+	out = append(out, name...)
+	out = append(out, u32_le(uint32(len(payload)))...)
+	checksum := Hash256(payload)
+	out = append(out, checksum[:4]...)
+	return append(out, payload...), nil
+}
+
+// ReadMessage reads one message of net from r. Messages it does not know
+// come back as a RawMessage.
+func ReadMessage(r io.Reader, net string) (Message, error) {
+	magic, ok := net_magic[net]
+	if !ok {
+		return nil, fmt.Errorf("unknown network %q", net)
+	}
+	envelope := make([]byte, envelope_size)
+	if _, err := io.ReadFull(r, envelope); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(envelope[:4], magic[:]) {
+		return nil, fmt.Errorf("magic %x is not %v's", envelope[:4], net)
+	}
+	name := envelope[4:16]
+	command := string(bytes.TrimRight(name, "\x00"))
+	for i, c := range name {
+		if (i < len(command) && (c < 0x20 || c > 0x7e)) || (i >= len(command) && c != 0) {
+			return nil, fmt.Errorf("malformed command %q", name)
+		}
+	}
+	length := binary.LittleEndian.Uint32(envelope[16:20])
+	if length > max_payload_size {
+		return nil, fmt.Errorf("%v payload of %v bytes is too large", command, length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if checksum := Hash256(payload); !bytes.Equal(checksum[:4], envelope[20:24]) {
+		return nil, fmt.Errorf("%v checksum %x does not match %x", command, envelope[20:24], checksum[:4])
+	}
+	return ParseMessage(command, payload)
+}
+
+// ParseMessage decodes the payload of a command.
+func ParseMessage(command string, payload []byte) (Message, error) {
+	r := &reader{b: payload}
+	var msg Message
+	switch command {
+	case "version":
+		msg = r.version_message()
+	case "verack":
+		msg = VerAckMessage{}
+	case "sendheaders":
+		msg = SendHeadersMessage{}
+	case "wtxidrelay":
+		msg = WTxIDRelayMessage{}
+	case "ping":
+		msg = PingMessage{nonce: r.u64()}
+	case "pong":
+		msg = PongMessage{nonce: r.u64()}
+	case "inv":
+		msg = InvMessage{items: r.inv_items()}
+	case "getdata":
+		msg = GetDataMessage{items: r.inv_items()}
+	case "getheaders":
+		msg = r.getheaders_message()
+	case "headers":
+		msg = r.headers_message()
+	case "block":
+		block, err := ParseBlock(payload)
+		if err != nil {
+			return nil, fmt.Errorf("block: %v", err)
+		}
+		return BlockMessage{block: block}, nil
+	case "tx":
+		tx, err := ParseTx(payload)
+		if err != nil {
+			return nil, fmt.Errorf("tx: %v", err)
+		}
+		return TxMessage{tx: tx}, nil
+	case "addr":
+		msg = r.addr_message()
+	case "feefilter":
+		msg = FeeFilterMessage{feerate: int64(r.u64())}
+	default:
+		return RawMessage{command: command, payload: payload}, nil
+	}
+	if err := r.done(); err != nil {
+		return nil, fmt.Errorf("%v: %v", command, err)
+	}
+	return msg, nil
+}
+
+// RawMessage is a message this package does not decode.
+type RawMessage struct {
+	command string
+	payload []byte
+}
+
+func (m RawMessage) Command() string { return m.command }
+func (m RawMessage) Payload() []byte { return m.payload }
+
+// NetAddress is a node's address as the version and addr messages carry
+// it. IPv4 addresses are mapped into IPv6 ones. The port is big endian.
+type NetAddress struct {
+	services uint64
+	ip       [16]byte
+	port     uint16
+}
+
+func (a NetAddress) encode() []byte {
+	out := make([]byte, 8, 26)
+	binary.LittleEndian.PutUint64(out, a.services)
+	out = append(out, a.ip[:]...)
+	return append(out, byte(a.port>>8), byte(a.port))
+}
+
+func (r *reader) net_address() (a NetAddress) {
+	a.services = r.u64()
+	copy(a.ip[:], r.read(16))
+	port := r.read(2)
+	a.port = uint16(port[0])<<8 | uint16(port[1])
+	return a
+}
+
+// Services a node can offer.
+const (
+	NODE_NETWORK         = 1 << 0
+	NODE_WITNESS         = 1 << 3
+	NODE_NETWORK_LIMITED = 1 << 10
+)
+
+// VersionMessage opens a connection: each side sends one and answers the
+// other's with a verack.
+type VersionMessage struct {
+	version      int32
+	services     uint64
+	timestamp    int64
+	addr_recv    NetAddress
+	addr_from    NetAddress
+	nonce        uint64 // detects connections to ourselves
+	user_agent   string
+	start_height int32
+	relay        bool // whether to announce transactions before a filter is set
+}
+
+func (m VersionMessage) Command() string { return "version" }
+
+func (m VersionMessage) Payload() []byte {
+	out := u32_le(uint32(m.version))
+	tmp := make([]byte, 8)
+	binary.LittleEndian.PutUint64(tmp, m.services)
+	out = append(out, tmp...)
+	binary.LittleEndian.PutUint64(tmp, uint64(m.timestamp))
+	out = append(out, tmp...)
+	out = append(out, m.addr_recv.encode()...)
+	out = append(out, m.addr_from.encode()...)
+	binary.LittleEndian.PutUint64(tmp, m.nonce)
+	out = append(out, tmp...)
+	out = append(out, encode_varint(uint64(len(m.user_agent)))...)
+	out = append(out, m.user_agent...)
+	out = append(out, u32_le(uint32(m.start_height))...)
+	if m.relay {
+		return append(out, 1)
+	}
+	return append(out, 0)
+}
+
+func (r *reader) version_message() (m VersionMessage) {
+	m.version = int32(r.u32())
+	m.services = r.u64()
+	m.timestamp = int64(r.u64())
+	m.addr_recv = r.net_address()
+	m.addr_from = r.net_address()
+	m.nonce = r.u64()
+	m.user_agent = string(r.var_bytes())
+	m.start_height = int32(r.u32())
+	// Old versions leave relay out, meaning true.
+	m.relay = true
+	if r.err == nil && r.off < len(r.b) {
+		m.relay = r.u8() != 0
+	}
+	return m
+}
+
+// Messages without a payload.
+type VerAckMessage struct{}
+type SendHeadersMessage struct{} // announce new blocks with headers, not inv
+type WTxIDRelayMessage struct{}  // announce transactions by wtxid (BIP339)
+
+func (VerAckMessage) Command() string      { return "verack" }
+func (VerAckMessage) Payload() []byte      { return nil }
+func (SendHeadersMessage) Command() string { return "sendheaders" }
+func (SendHeadersMessage) Payload() []byte { return nil }
+func (WTxIDRelayMessage) Command() string  { return "wtxidrelay" }
+func (WTxIDRelayMessage) Payload() []byte  { return nil }
+
+// PingMessage checks the connection is alive; the peer answers with a
+// PongMessage with the same nonce.
+type PingMessage struct{ nonce uint64 }
+type PongMessage struct{ nonce uint64 }
+
+func (m PingMessage) Command() string { return "ping" }
+func (m PingMessage) Payload() []byte { return u64_le(m.nonce) }
+func (m PongMessage) Command() string { return "pong" }
+func (m PongMessage) Payload() []byte { return u64_le(m.nonce) }
+
+func u64_le(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, n)
+	return b
+}
+
+// Inventory types. MSG_WITNESS_FLAG asks for blocks and transactions with
+// their witnesses.
+const (
+	MSG_TX           = 1
+	MSG_BLOCK        = 2
+	MSG_WTX          = 5
+	MSG_WITNESS_FLAG = 1 << 30
+)
+
+// InvVector names a transaction or block.
+type InvVector struct {
+	kind uint32
+	hash Hash
+}
+
+func encode_inv_items(items []InvVector) []byte {
+	out := encode_varint(uint64(len(items)))
+	for _, item := range items {
+		out = append(out, u32_le(item.kind)...)
+		out = append(out, item.hash[:]...)
+	}
+	return out
+}
+
+func (r *reader) inv_items() []InvVector {
+	n := r.count(36)
+	if n > max_inv_items {
+		r.err = fmt.Errorf("%v inventory items, at most %v allowed", n, max_inv_items)
+		return nil
+	}
+	items := make([]InvVector, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		items = append(items, InvVector{kind: r.u32(), hash: r.hash()})
+	}
+	return items
+}
+
+// InvMessage announces transactions or blocks; GetDataMessage asks for them.
+type InvMessage struct{ items []InvVector }
+type GetDataMessage struct{ items []InvVector }
+
+func (m InvMessage) Command() string     { return "inv" }
+func (m InvMessage) Payload() []byte     { return encode_inv_items(m.items) }
+func (m GetDataMessage) Command() string { return "getdata" }
+func (m GetDataMessage) Payload() []byte { return encode_inv_items(m.items) }
+
+// GetHeadersMessage asks for the headers after the first block of locator
+// the peer has on its active chain, up to stop or 2000 headers. A zero stop
+// means as many as possible.
+type GetHeadersMessage struct {
+	version uint32
+	locator []Hash // newest first
+	stop    Hash
+}
+
+func (m GetHeadersMessage) Command() string { return "getheaders" }
+
+func (m GetHeadersMessage) Payload() []byte {
+	out := u32_le(m.version)
+	out = append(out, encode_varint(uint64(len(m.locator)))...)
+	for _, h := range m.locator {
+		out = append(out, h[:]...)
+	}
+	return append(out, m.stop[:]...)
+}
+
+func (r *reader) getheaders_message() (m GetHeadersMessage) {
+	m.version = r.u32()
+	n := r.count(32)
+	if n > max_locator_size {
+		r.err = fmt.Errorf("locator of %v hashes, at most %v allowed", n, max_locator_size)
+		return m
+	}
+	for i := 0; i < n && r.err == nil; i++ {
+		m.locator = append(m.locator, r.hash())
+	}
+	m.stop = r.hash()
+	return m
+}
+
+// HeadersMessage answers getheaders. Each header is followed by a
+// transaction count, always zero.
+type HeadersMessage struct{ headers []BlockHeader }
+
+func (m HeadersMessage) Command() string { return "headers" }
+
+func (m HeadersMessage) Payload() []byte {
+	out := encode_varint(uint64(len(m.headers)))
+	for _, h := range m.headers {
+		out = append(out, h.HeaderEncode()...)
+		out = append(out, 0)
+	}
+	return out
+}
+
+func (r *reader) headers_message() (m HeadersMessage) {
+	n := r.count(header_size + 1)
+	if n > max_headers {
+		r.err = fmt.Errorf("%v headers, at most %v allowed", n, max_headers)
+		return m
+	}
+	for i := 0; i < n && r.err == nil; i++ {
+		m.headers = append(m.headers, r.header())
+		if txs := r.varint(); txs != 0 && r.err == nil {
+			r.err = fmt.Errorf("header %v has a transaction count of %v", i, txs)
+		}
+	}
+	return m
+}
+
+type BlockMessage struct{ block Block }
+type TxMessage struct{ tx Tx }
+
+func (m BlockMessage) Command() string { return "block" }
+func (m BlockMessage) Payload() []byte { return m.block.BlockEncode() }
+func (m TxMessage) Command() string    { return "tx" }
+func (m TxMessage) Payload() []byte    { return m.tx.TxEncode(-1) }
+
+// TimedAddress is an address with when the node was last seen.
+type TimedAddress struct {
+	timestamp uint32
+	addr      NetAddress
+}
+
+// AddrMessage shares addresses of other nodes.
+type AddrMessage struct{ addrs []TimedAddress }
+
+func (m AddrMessage) Command() string { return "addr" }
+
+func (m AddrMessage) Payload() []byte {
+	out := encode_varint(uint64(len(m.addrs)))
+	for _, a := range m.addrs {
+		out = append(out, u32_le(a.timestamp)...)
+		out = append(out, a.addr.encode()...)
+	}
+	return out
+}
+
+func (r *reader) addr_message() (m AddrMessage) {
+	n := r.count(30)
+	if n > max_addresses {
+		r.err = fmt.Errorf("%v addresses, at most %v allowed", n, max_addresses)
+		return m
+	}
+	for i := 0; i < n && r.err == nil; i++ {
+		m.addrs = append(m.addrs, TimedAddress{timestamp: r.u32(), addr: r.net_address()})
+	}
+	return m
+}
+
+// FeeFilterMessage asks the peer not to announce transactions paying less
+// than feerate, in sat per 1000 vbytes (BIP133).
+type FeeFilterMessage struct{ feerate int64 }
+
+func (m FeeFilterMessage) Command() string { return "feefilter" }
+func (m FeeFilterMessage) Payload() []byte { return u64_le(uint64(m.feerate)) }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestEncodeMessage(t *testing.T) {
+	b, err := EncodeMessage("main", VerAckMessage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(b); got != "f9beb4d976657261636b000000000000000000005df6e0e2" {
+		t.Errorf("verack is %v", got)
+	}
+	if _, err := EncodeMessage("signet2", VerAckMessage{}); err == nil {
+		t.Error("encoded a message for an unknown network")
+	}
+}
+
+// Examples from the Bitcoin developer reference (developer.bitcoin.org,
+// "P2P Network"). The version message is given with its header.
+const (
+	version_fixture = "f9beb4d976657273696f6e0000000000650000005f1a69d2" +
+		"721101000100000000000000bc8f5e5400000000" +
+		"010000000000000000000000000000000000ffffc61b6409208d" +
+		"010000000000000000000000000000000000ffffcb0071c0208d" +
+		"128035cbc97953f80f2f5361746f7368693a302e392e332fcf05050001"
+	getheaders_fixture = "7111010002" +
+		"d39f608a7775b537729884d4e6633bb2105e55a16a14d31b0000000000000000" +
+		"5c3e6403d40837110a2e8afb602b1c01714bda7ce23bea0a0000000000000000" +
+		"0000000000000000000000000000000000000000000000000000000000000000"
+	headers_fixture = "0102000000" +
+		"b6ff0b1b1680a2862a30ca44d346d9e8910d334beb48ca0c0000000000000000" +
+		"9d10aa52ee949386ca9385695f04ede270dda20810decd12bc9b048aaab31471" +
+		"24d95a5430c31b18fe9f086400"
+	addr_fixture = "01d91f4854010000000000000000000000000000000000ffffc0000233208d"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	fixtures := []struct {
+		msg     Message
+		payload string
+	}{
+		{VersionMessage{
+			version:      70002,
+			services:     NODE_NETWORK,
+			timestamp:    1415483324,
+			addr_recv:    NetAddress{services: NODE_NETWORK, ip: [16]byte{10: 0xff, 11: 0xff, 12: 198, 13: 27, 14: 100, 15: 9}, port: 8333},
+			addr_from:    NetAddress{services: NODE_NETWORK, ip: [16]byte{10: 0xff, 11: 0xff, 12: 203, 13: 0, 14: 113, 15: 192}, port: 8333},
+			nonce:        0xf85379c9cb358012,
+			user_agent:   "/Satoshi:0.9.3/",
+			start_height: 329167,
+			relay:        true,
+		}, version_fixture[48:]},
+		{GetHeadersMessage{version: 70001, locator: []Hash{
+			unhex_hash(t, "d39f608a7775b537729884d4e6633bb2105e55a16a14d31b0000000000000000"),
+			unhex_hash(t, "5c3e6403d40837110a2e8afb602b1c01714bda7ce23bea0a0000000000000000"),
+		}}, getheaders_fixture},
+		{HeadersMessage{headers: []BlockHeader{{
+			version:     2,
+			prev_block:  unhex_hash(t, "b6ff0b1b1680a2862a30ca44d346d9e8910d334beb48ca0c0000000000000000"),
+			merkle_root: unhex_hash(t, "9d10aa52ee949386ca9385695f04ede270dda20810decd12bc9b048aaab31471"),
+			timestamp:   1415239972,
+			bits:        0x181bc330,
+			nonce:       0x64089ffe,
+		}}}, headers_fixture},
+		{AddrMessage{addrs: []TimedAddress{{1414012889, NetAddress{services: NODE_NETWORK, ip: [16]byte{10: 0xff, 11: 0xff, 12: 192, 13: 0, 14: 2, 15: 51}, port: 8333}}}}, addr_fixture},
+	}
+	for _, f := range fixtures {
+		if got := hex.EncodeToString(f.msg.Payload()); got != f.payload {
+			t.Errorf("%v payload is %v, want %v", f.msg.Command(), got, f.payload)
+		}
+		parsed, err := ParseMessage(f.msg.Command(), unhex(t, f.payload))
+		if err != nil || hex.EncodeToString(parsed.Payload()) != f.payload {
+			t.Errorf("%v does not parse back: %v", f.msg.Command(), err)
+		}
+	}
+	if b, _ := EncodeMessage("main", fixtures[0].msg); hex.EncodeToString(b) != version_fixture {
+		t.Errorf("version message is %x", b)
+	}
+
+	g, _ := GenesisBlock("main")
+	coinbase := g.txs[0]
+	version := NewVersionMessage(5, 42)
+	version.addr_recv = NetAddress{services: NODE_NETWORK, ip: [16]byte{10: 0xff, 11: 0xff, 12: 127, 15: 1}, port: 8333}
+	messages := []Message{
+		version,
+		VerAckMessage{},
+		SendHeadersMessage{},
+		WTxIDRelayMessage{},
+		PingMessage{nonce: 7},
+		PongMessage{nonce: 7},
+		InvMessage{items: []InvVector{{MSG_TX, coinbase.TxID()}, {MSG_BLOCK, g.BlockHash()}}},
+		GetDataMessage{items: []InvVector{{MSG_BLOCK | MSG_WITNESS_FLAG, g.BlockHash()}}},
+		GetHeadersMessage{version: protocol_version, locator: []Hash{g.BlockHash()}},
+		HeadersMessage{headers: []BlockHeader{g.header}},
+		BlockMessage{block: g},
+		TxMessage{tx: coinbase},
+		AddrMessage{addrs: []TimedAddress{{12345, version.addr_recv}}},
+		FeeFilterMessage{feerate: 1000},
+		RawMessage{command: "foo", payload: []byte{1, 2}},
+	}
+	var stream []byte
+	for _, msg := range messages {
+		b, err := EncodeMessage("test", msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, b...)
+	}
+	r := bytes.NewReader(stream)
+	for _, msg := range messages {
+		got, err := ReadMessage(r, "test")
+		if err != nil {
+			t.Fatalf("%v: %v", msg.Command(), err)
+		}
+		if got.Command() != msg.Command() || !bytes.Equal(got.Payload(), msg.Payload()) {
+			t.Errorf("%v came back as %v %x", msg.Command(), got.Command(), got.Payload())
+		}
+	}
+	if r.Len() != 0 {
+		t.Errorf("%v bytes left over", r.Len())
+	}
+}
+
+func TestReadMessageRejects(t *testing.T) {
+	b, _ := EncodeMessage("test", PingMessage{nonce: 7})
+	if _, err := ReadMessage(bytes.NewReader(b), "main"); err == nil {
+		t.Error("read a testnet message on mainnet")
+	}
+	bad := append([]byte(nil), b...)
+	bad[len(bad)-1] ^= 1
+	if _, err := ReadMessage(bytes.NewReader(bad), "test"); err == nil {
+		t.Error("accepted a bad checksum")
+	}
+	if _, err := ReadMessage(bytes.NewReader(b[:len(b)-1]), "test"); err == nil {
+		t.Error("accepted a truncated payload")
+	}
+	if _, err := ParseMessage("ping", []byte{1, 2, 3}); err == nil {
+		t.Error("accepted a short ping")
+	}
+	if _, err := ParseMessage("pong", make([]byte, 9)); err == nil {
+		t.Error("accepted a pong with trailing data")
+	}
+	too_many := append(encode_varint(max_headers+1), make([]byte, 81)...)
+	if _, err := ParseMessage("headers", too_many); err == nil {
+		t.Error("accepted more than max_headers headers")
+	}
+}
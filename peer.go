@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Peer speaks the protocol over a connection, normally a net.Conn.
+type Peer struct {
+	conn    io.ReadWriteCloser
+	net     string
+	version VersionMessage // the remote's, once the handshake is done
+}
+
+func NewPeer(conn io.ReadWriteCloser, net string) *Peer {
+	return &Peer{conn: conn, net: net}
+}
+
+// LoopbackPeers returns two peers connected to each other in memory, for
+// tests and for serving a local chain without a network.
+func LoopbackPeers(net string) (*Peer, *Peer) {
+	a, b := new_pipe(), new_pipe()
+	return NewPeer(&pipe_conn{a, b}, net), NewPeer(&pipe_conn{b, a}, net)
+}
+
+func (p *Peer) Send(msg Message) error {
+	b, err := EncodeMessage(p.net, msg)
+	if err != nil {
+		return err
+	}
+	_, err = p.conn.Write(b)
+	return err
+}
+
+func (p *Peer) Receive() (Message, error) {
+	return ReadMessage(p.conn, p.net)
+}
+
+func (p *Peer) Close() error {
+	return p.conn.Close()
+}
+
+// NewVersionMessage is our version message, for a node with the chain up
+// to start_height. nonce should be random.
+func NewVersionMessage(start_height int32, nonce uint64) VersionMessage {
+	return VersionMessage{
+		version:      protocol_version,
+		services:     NODE_WITNESS,
+		timestamp:    time.Now().Unix(),
+		nonce:        nonce,
+		user_agent:   "/tbtc:0.1/",
+		start_height: start_height,
+		relay:        false,
+	}
+}
+
+// Handshake exchanges version and verack messages. Both sides may call it at
+// the same time. The peer must send its version before its verack. Other
+// messages received meanwhile, such as wtxidrelay or sendheaders, are
+// ignored.
+func (p *Peer) Handshake(ours VersionMessage) error {
+	if err := p.Send(ours); err != nil {
+		return err
+	}
+	got_version, got_verack := false, false
+	for !got_version || !got_verack {
+		msg, err := p.Receive()
+		if err != nil {
+			return err
+		}
+		switch m := msg.(type) {
+		case VersionMessage:
+			if m.nonce == ours.nonce {
+				return fmt.Errorf("connected to ourselves")
+			}
+			p.version, got_version = m, true
+			if err := p.Send(VerAckMessage{}); err != nil {
+				return err
+			}
+		case VerAckMessage:
+			if !got_version {
+				return fmt.Errorf("verack before version")
+			}
+			got_verack = true
+		}
+	}
+	return nil
+}
+
+// Serve answers the peer's ping and getheaders messages from chain until
+// the connection fails or closes.
+func (p *Peer) Serve(chain *HeaderChain) error {
+	for {
+		msg, err := p.Receive()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := p.answer(msg, chain); err != nil {
+			return err
+		}
+	}
+}
+
+// answer replies to the messages a peer must answer.
+func (p *Peer) answer(msg Message, chain *HeaderChain) error {
+	switch m := msg.(type) {
+	case PingMessage:
+		return p.Send(PongMessage{nonce: m.nonce})
+	case GetHeadersMessage:
+		if chain != nil {
+			return p.Send(HeadersMessage{headers: chain.HeadersAfter(m.locator, m.stop)})
+		}
+	}
+	return nil
+}
+
+// SyncHeaders downloads headers into chain with getheaders until the peer
+// has no more. It also stops when a batch leaves the tip where it was, as a
+// peer resending headers we have would otherwise be asked for them forever.
+func (p *Peer) SyncHeaders(chain *HeaderChain) error {
+	for {
+		if err := p.Send(GetHeadersMessage{version: protocol_version, locator: chain.Locator()}); err != nil {
+			return err
+		}
+		var headers []BlockHeader
+		for {
+			msg, err := p.Receive()
+			if err != nil {
+				return err
+			}
+			if m, ok := msg.(HeadersMessage); ok {
+				headers = m.headers
+				break
+			}
+			if err := p.answer(msg, chain); err != nil {
+				return err
+			}
+		}
+		tip := chain.Tip()
+		if err := chain.AddHeaders(headers); err != nil {
+			return err
+		}
+		if len(headers) < max_headers || chain.Tip() == tip {
+			return nil
+		}
+	}
+}
+
+// Locator lists hashes of the active chain for getheaders: the last ten
+// blocks, then going back twice as far each step, then the genesis block.
+func (c *HeaderChain) Locator() []Hash {
+	var locator []Hash
+	step := 1
+	for height := c.Height(); height > 0; height -= step {
+		locator = append(locator, c.active[height].hash)
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+	return append(locator, c.active[0].hash)
+}
+
+// HeadersAfter answers a getheaders: the active chain's headers after the
+// first locator hash on it, up to stop and at most 2000.
+func (c *HeaderChain) HeadersAfter(locator []Hash, stop Hash) []BlockHeader {
+	start := 0
+	for _, h := range locator {
+		if height, ok := c.HeightOf(h); ok {
+			start = height
+			break
+		}
+	}
+	var headers []BlockHeader
+	for height := start + 1; height <= c.Height() && len(headers) < max_headers; height++ {
+		e := c.active[height]
+		headers = append(headers, e.header)
+		if e.hash == stop {
+			break
+		}
+	}
+	return headers
+}
+
+// pipe is one direction of a loopback connection. Unlike net.Pipe, writes
+// do not wait for the reader, so both ends can send before reading.
+type pipe struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func new_pipe() *pipe {
+	p := &pipe{}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *pipe) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.buf.Len() == 0 && !p.closed {
+		p.cond.Wait()
+	}
+	if p.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return p.buf.Read(b)
+}
+
+func (p *pipe) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, io.ErrClosedPipe
+	}
+	p.buf.Write(b)
+	p.cond.Broadcast()
+	return len(b), nil
+}
+
+func (p *pipe) close() {
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+// pipe_conn reads from in and writes to out. Closing it closes both
+// directions, as closing a socket does.
+type pipe_conn struct {
+	in, out *pipe
+}
+
+func (c *pipe_conn) Read(b []byte) (int, error)  { return c.in.Read(b) }
+func (c *pipe_conn) Write(b []byte) (int, error) { return c.out.Write(b) }
+
+func (c *pipe_conn) Close() error {
+	c.in.close()
+	c.out.close()
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import "testing"
+
+// regtest_chain returns a regtest chain n headers long.
+func regtest_chain(t *testing.T, n int) (*HeaderChain, []BlockHeader) {
+	t.Helper()
+	c, _ := NewHeaderChain("regtest")
+	g, _ := GenesisBlock("regtest")
+	headers := extend_headers(g.header, n, 0, c.params)
+	if err := c.AddHeaders(headers); err != nil {
+		t.Fatal(err)
+	}
+	return c, headers
+}
+
+func TestSyncHeaders(t *testing.T) {
+	server, _ := regtest_chain(t, max_headers+100)
+	a, b := LoopbackPeers("regtest")
+	done := make(chan error, 1)
+	go func() {
+		if err := b.Handshake(NewVersionMessage(int32(server.Height()), 1)); err != nil {
+			done <- err
+			return
+		}
+		done <- b.Serve(server)
+	}()
+	if err := a.Handshake(NewVersionMessage(0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if a.version.start_height != int32(server.Height()) {
+		t.Errorf("peer's start height is %v", a.version.start_height)
+	}
+	client, _ := NewHeaderChain("regtest")
+	if err := a.SyncHeaders(client); err != nil {
+		t.Fatal(err)
+	}
+	if client.Tip() != server.Tip() || client.Height() != server.Height() {
+		t.Fatalf("synced to height %v", client.Height())
+	}
+	if err := a.Send(PingMessage{nonce: 99}); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := a.Receive(); err != nil || msg != (PongMessage{nonce: 99}) {
+		t.Fatalf("ping answered with %v: %v", msg, err)
+	}
+	a.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A peer that answers every getheaders with the same full batch of headers
+// we already have must not keep us asking forever.
+func TestSyncHeadersStale(t *testing.T) {
+	chain, headers := regtest_chain(t, max_headers)
+	a, b := LoopbackPeers("regtest")
+	requests := make(chan int, 1)
+	go func() {
+		n := 0
+		defer func() { requests <- n }()
+		for n < 3 {
+			msg, err := b.Receive()
+			if err != nil {
+				return
+			}
+			if _, ok := msg.(GetHeadersMessage); ok {
+				n++
+				b.Send(HeadersMessage{headers: headers})
+			}
+		}
+		b.Close()
+	}()
+	if err := a.SyncHeaders(chain); err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+	if n := <-requests; n != 1 {
+		t.Errorf("asked %v times", n)
+	}
+}
+
+func TestHandshakeEarlyVerAck(t *testing.T) {
+	a, b := LoopbackPeers("regtest")
+	defer a.Close()
+	b.Send(VerAckMessage{})
+	b.Send(NewVersionMessage(0, 1))
+	if err := a.Handshake(NewVersionMessage(0, 2)); err == nil {
+		t.Error("accepted a verack before the version")
+	}
+}
+
+func TestHandshakeSelf(t *testing.T) {
+	a, b := LoopbackPeers("regtest")
+	defer a.Close()
+	b.Send(NewVersionMessage(0, 2))
+	if err := a.Handshake(NewVersionMessage(0, 2)); err == nil {
+		t.Error("handshake with our own nonce")
+	}
+}
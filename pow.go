@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// ChainParams holds the proof of work rules of a network.
+type ChainParams struct {
+	pow_limit_bits  uint32
+	target_timespan uint32 // seconds one retarget interval should take
+	target_spacing  uint32 // seconds between blocks
+	min_difficulty  bool   // testnet's 20 minute rule
+	no_retargeting  bool
+}
+
+var chain_params = map[string]ChainParams{
+	"main":    {0x1d00ffff, 14 * 24 * 60 * 60, 10 * 60, false, false},
+	"test":    {0x1d00ffff, 14 * 24 * 60 * 60, 10 * 60, true, false},
+	"regtest": {0x207fffff, 14 * 24 * 60 * 60, 10 * 60, true, true},
+}
+
+func NetParams(net string) (ChainParams, error) {
+	params, ok := chain_params[net]
+	if !ok {
+		return ChainParams{}, fmt.Errorf("unknown network %q", net)
+	}
+	return params, nil
+}
+
+// interval is the number of blocks between retargets, 2016.
+func (p ChainParams) interval() int {
+	return int(p.target_timespan / p.target_spacing)
+}
+
+func (p ChainParams) pow_limit() *big.Int {
+	limit, _ := BitsToTarget(p.pow_limit_bits)
+	return limit
+}
+
+// BitsToTarget expands the compact form headers store their target in: the
+// top byte is a length in bytes and the other three the most significant
+// bytes, a floating point number of sorts. Bit 0x00800000 is a sign bit,
+// and negative or oversized targets are errors.
+func BitsToTarget(bits uint32) (*big.Int, error) {
+	size := bits >> 24
+	word := bits & 0x007fffff
+	target := new(big.Int)
+	if size <= 3 {
+		target.SetUint64(uint64(word >> (8 * (3 - size))))
+	} else {
+		target.Lsh(new(big.Int).SetUint64(uint64(word)), uint(8*(size-3)))
+	}
+	if word != 0 && bits&0x00800000 != 0 {
+		return nil, fmt.Errorf("bits %#08x encode a negative target", bits)
+	}
+	if word != 0 && (size > 34 || (word > 0xff && size > 33) || (word > 0xffff && size > 32)) {
+		return nil, fmt.Errorf("bits %#08x encode a target over 256 bits", bits)
+	}
+	return target, nil
+}
+
+// TargetToBits is the compact form of target, rounded down.
+func TargetToBits(target *big.Int) uint32 {
+	size := uint32((target.BitLen() + 7) / 8)
+	var word uint32
+	if size <= 3 {
+		word = uint32(target.Uint64() << (8 * (3 - size)))
+	} else {
+		word = uint32(new(big.Int).Rsh(target, uint(8*(size-3))).Uint64())
+	}
+	// Keep the sign bit clear.
+	if word&0x00800000 != 0 {
+		word >>= 8
+		size++
+	}
+	return size<<24 | word
+}
+
+// hash_number reads a block hash as the number compared with the target.
+// Hashes are stored little endian, which is why they are shown reversed.
+func hash_number(h Hash) *big.Int {
+	reverse(h[:])
+	return new(big.Int).SetBytes(h[:])
+}
+
+// CheckProofOfWork checks that the header's hash is at or below the target
+// its bits claim, and that the target is no easier than the network allows.
+func (h BlockHeader) CheckProofOfWork(params ChainParams) error {
+	target, err := BitsToTarget(h.bits)
+	if err != nil {
+		return err
+	}
+	if target.Sign() == 0 || target.Cmp(params.pow_limit()) > 0 {
+		return fmt.Errorf("bits %#08x are out of range", h.bits)
+	}
+	if hash := h.BlockHash(); hash_number(hash).Cmp(target) > 0 {
+		return fmt.Errorf("block hash %s is above the target of %#08x", hash, h.bits)
+	}
+	return nil
+}
+
+// BlockWork is the expected number of hashes needed to find a block with
+// these bits, 2^256 / (target+1). Chainwork is its sum over a chain.
+func BlockWork(bits uint32) *big.Int {
+	target, err := BitsToTarget(bits)
+	if err != nil || target.Sign() == 0 {
+		return new(big.Int)
+	}
+	denominator := target.Add(target, big.NewInt(1))
+	return denominator.Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
+
+// RetargetBits computes the bits of the first block of an interval from the
+// last block of the previous one and the time of that interval's first
+// block. The target scales with how long the interval took, clamped to a
+// quarter and four times the target timespan, and never gets easier than
+// the network's limit. Like Bitcoin Core, the time is measured over 2015
+// blocks, not 2016.
+func RetargetBits(last_bits, first_time, last_time uint32, params ChainParams) uint32 {
+	if params.no_retargeting {
+		return last_bits
+	}
+	timespan := int64(last_time) - int64(first_time)
+	if lowest := int64(params.target_timespan / 4); timespan < lowest {
+		timespan = lowest
+	}
+	if highest := int64(params.target_timespan * 4); timespan > highest {
+		timespan = highest
+	}
+	target, err := BitsToTarget(last_bits)
+	if err != nil {
+		return params.pow_limit_bits
+	}
+	target.Mul(target, big.NewInt(timespan))
+	target.Div(target, big.NewInt(int64(params.target_timespan)))
+	if limit := params.pow_limit(); target.Cmp(limit) > 0 {
+		target = limit
+	}
+	return TargetToBits(target)
+}
+
+// NextWorkRequired returns the bits a block after prev, at height
+// prev_height+1 and with timestamp new_time, must have. ancestor returns the
+// header at a lower height on the same chain.
+//
+// Between retargets the bits stay the same, except on testnet: a block more
+// than twice the target spacing (20 minutes) after its parent may use the
+// minimum difficulty, and the block after that goes back to the bits of the
+// last block that did not.
+func NextWorkRequired(prev_height int, prev BlockHeader, new_time uint32, ancestor func(height int) BlockHeader, params ChainParams) uint32 {
+	interval := params.interval()
+	if (prev_height+1)%interval != 0 {
+		if !params.min_difficulty {
+			return prev.bits
+		}
+		if new_time > prev.timestamp+2*params.target_spacing {
+			return params.pow_limit_bits
+		}
+		height, header := prev_height, prev
+		for height > 0 && height%interval != 0 && header.bits == params.pow_limit_bits {
+			height--
+			header = ancestor(height)
+		}
+		return header.bits
+	}
+	first := ancestor(prev_height - (interval - 1))
+	return RetargetBits(prev.bits, first.timestamp, prev.timestamp, params)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+// Retargets from Bitcoin Core's pow_tests.
+func TestRetargetBits(t *testing.T) {
+	params, _ := NetParams("main")
+	tests := []struct {
+		last_bits, first_time, last_time, want uint32
+	}{
+		{0x1d00ffff, 1261130161, 1262152739, 0x1d00d86a},
+		{0x1d00ffff, 1231006505, 1233061996, 0x1d00ffff}, // clamped to the pow limit
+		{0x1c05a3f4, 1279008237, 1279297671, 0x1c0168fd}, // at most 4 times harder
+		{0x1c387f6f, 1263163443, 1269211443, 0x1d00e1fd}, // at most 4 times easier
+	}
+	for _, test := range tests {
+		if got := RetargetBits(test.last_bits, test.first_time, test.last_time, params); got != test.want {
+			t.Errorf("retarget from %08x is %08x, want %08x", test.last_bits, got, test.want)
+		}
+	}
+}
+
+func TestCompactBits(t *testing.T) {
+	for _, bits := range []uint32{0x1d00ffff, 0x1c05a3f4, 0x207fffff, 0x03123456, 0x04123456, 0x01120000, 0x02123400, 0x05009234} {
+		target, err := BitsToTarget(bits)
+		if err != nil {
+			t.Fatalf("%08x: %v", bits, err)
+		}
+		if got := TargetToBits(target); got != bits {
+			t.Errorf("%08x is target %x, which encodes as %08x", bits, target, got)
+		}
+	}
+	if _, err := BitsToTarget(0x04923456); err == nil {
+		t.Error("accepted a negative target")
+	}
+	if _, err := BitsToTarget(0xff123456); err == nil {
+		t.Error("accepted a target over 256 bits")
+	}
+	// The top bit of the mantissa is a sign, so 0x80 needs another byte.
+	if got := TargetToBits(big.NewInt(0x80)); got != 0x02008000 {
+		t.Errorf("0x80 encodes as %08x", got)
+	}
+	if got := BlockWork(0x1d00ffff); got.Cmp(big.NewInt(4295032833)) != 0 {
+		t.Errorf("work of difficulty 1 is %v", got)
+	}
+}
+
+func TestCheckProofOfWork(t *testing.T) {
+	for _, net := range []string{"main", "test", "regtest"} {
+		g, _ := GenesisBlock(net)
+		params, _ := NetParams(net)
+		if err := g.header.CheckProofOfWork(params); err != nil {
+			t.Errorf("%v genesis block: %v", net, err)
+		}
+	}
+	g, _ := GenesisBlock("main")
+	params, _ := NetParams("main")
+	header := g.header
+	header.nonce++
+	if err := header.CheckProofOfWork(params); err == nil {
+		t.Error("accepted the genesis header with another nonce")
+	}
+}
+
+func TestNextWorkRequired(t *testing.T) {
+	main, _ := NetParams("main")
+	test, _ := NetParams("test")
+	test.target_timespan = 10 * 600
+	chain := []BlockHeader{
+		{bits: 0x1c0fffff, timestamp: 1000},
+		{bits: 0x1c0fffff, timestamp: 1600},
+		{bits: 0x1d00ffff, timestamp: 2900}, // mined after 20 minutes at the minimum
+		{bits: 0x1d00ffff, timestamp: 4200},
+	}
+	ancestor := func(height int) BlockHeader { return chain[height] }
+	tip := len(chain) - 1
+	// Testnet walks back past minimum difficulty blocks to the real target.
+	if got := NextWorkRequired(tip, chain[tip], chain[tip].timestamp+100, ancestor, test); got != 0x1c0fffff {
+		t.Errorf("testnet block on time needs %08x", got)
+	}
+	if got := NextWorkRequired(tip, chain[tip], chain[tip].timestamp+1201, ancestor, test); got != 0x1d00ffff {
+		t.Errorf("testnet block after 20 minutes needs %08x", got)
+	}
+	if got := NextWorkRequired(tip, chain[tip], chain[tip].timestamp+1201, ancestor, main); got != 0x1d00ffff {
+		t.Errorf("mainnet block needs %08x", got)
+	}
+}
+
+// TestNextWorkRequiredRetarget crosses a retarget boundary with a 10 block
+// interval. The interval is timed from its first block, height 0, to its
+// last, height 9; timing it from height 1 would clamp the timespan and give
+// 0x1c03ffff instead.
+func TestNextWorkRequiredRetarget(t *testing.T) {
+	main, _ := NetParams("main")
+	main.target_timespan = 10 * 600
+	test, _ := NetParams("test")
+	test.target_timespan = 10 * 600
+	chain := []BlockHeader{{bits: 0x1c0fffff, timestamp: 10000}}
+	for i := 1; i < 10; i++ {
+		chain = append(chain, BlockHeader{bits: 0x1c0fffff, timestamp: uint32(13000 - (9-i)*100)})
+	}
+	ancestor := func(height int) BlockHeader { return chain[height] }
+	tip := len(chain) - 1
+	if got := NextWorkRequired(tip-1, chain[tip-1], chain[tip].timestamp, ancestor, main); got != 0x1c0fffff {
+		t.Errorf("block before the boundary needs %08x", got)
+	}
+	// Half the target timespan: the target halves.
+	if got := NextWorkRequired(tip, chain[tip], chain[tip].timestamp+600, ancestor, main); got != 0x1c07ffff {
+		t.Errorf("first block of the interval needs %08x, want 1c07ffff", got)
+	}
+	// The 20 minute rule does not apply on a boundary.
+	if got := NextWorkRequired(tip, chain[tip], chain[tip].timestamp+1201, ancestor, test); got != 0x1c07ffff {
+		t.Errorf("testnet first block of the interval needs %08x, want 1c07ffff", got)
+	}
+}
@@ -0,0 +1,989 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// Partially Signed Bitcoin Transactions (BIP174). A PSBT carries an
+// unsigned transaction together with what each party needs to sign it, so
+// it can be passed from the machine that creates it to the ones holding the
+// keys (possibly offline), combined and turned into the final transaction.
+
+var psbt_magic = []byte("psbt\xff")
+
+// Key types. The global, input and output maps each have their own.
+const (
+	psbt_global_unsigned_tx = 0x00
+	psbt_global_xpub        = 0x01
+	psbt_global_version     = 0xfb
+
+	psbt_in_non_witness_utxo     = 0x00
+	psbt_in_witness_utxo         = 0x01
+	psbt_in_partial_sig          = 0x02
+	psbt_in_sighash_type         = 0x03
+	psbt_in_redeem_script        = 0x04
+	psbt_in_witness_script       = 0x05
+	psbt_in_bip32_derivation     = 0x06
+	psbt_in_final_script_sig     = 0x07
+	psbt_in_final_script_witness = 0x08
+
+	psbt_out_redeem_script    = 0x00
+	psbt_out_witness_script   = 0x01
+	psbt_out_bip32_derivation = 0x02
+)
+
+// psbt_in_keyless holds the BIP174 input key types whose key is the type
+// alone. Others carry data in the key: a public key for partial signatures
+// and derivations, a hash for the preimage fields (0x0a to 0x0d). The
+// version 2 types are only their field with the type alone as key; with
+// data they are unknown entries, as BIP174 parsers have always read them.
+var psbt_in_keyless = map[byte]bool{
+	psbt_in_non_witness_utxo:     true,
+	psbt_in_witness_utxo:         true,
+	psbt_in_sighash_type:         true,
+	psbt_in_redeem_script:        true,
+	psbt_in_witness_script:       true,
+	psbt_in_final_script_sig:     true,
+	psbt_in_final_script_witness: true,
+}
+
+// psbt_kv is one entry of a map. key starts with the key type.
+type psbt_kv struct {
+	key   []byte
+	value []byte
+}
+
+// Bip32Derivation says which HD key a public key is: the fingerprint of the
+// master key and the derivation path from it.
+type Bip32Derivation struct {
+	PubKey      []byte
+	Fingerprint [4]byte
+	Path        []uint32
+}
+
+type PSBTInput struct {
+	non_witness_utxo     *Tx
+	witness_utxo         *TxOut
+	partial_sigs         []psbt_kv // public key -> signature with sighash byte
+	sighash_type         uint32    // 0 if not set
+	redeem_script        []byte
+	witness_script       []byte
+	bip32                []Bip32Derivation
+	final_script_sig     []byte
+	final_script_witness [][]byte
+	unknown              []psbt_kv
+
+	// Version 2 only: the locktime the input needs, by time or by height.
+	required_time   uint32
+	required_height uint32
+}
+
+type PSBTOutput struct {
+	redeem_script  []byte
+	witness_script []byte
+	bip32          []Bip32Derivation
+	unknown        []psbt_kv
+}
+
+type PSBT struct {
+	version    uint32 // 0 or 2
+	tx         Tx     // unsigned: no scriptSigs or witnesses
+	modifiable byte   // version 2 only, see psbt_v2.go
+	// Version 2 only: whether the global map holds a fallback locktime,
+	// which may be 0.
+	has_fallback bool
+	xpubs        []psbt_kv
+	inputs       []PSBTInput
+	outputs      []PSBTOutput
+	unknown      []psbt_kv
+}
+
+// NewPSBT is the creator role: it wraps an unsigned transaction.
+func NewPSBT(tx Tx) (*PSBT, error) {
+	for i, tx_in := range tx.tx_ins {
+		if (tx_in.script_sig != nil && len(script_body(tx_in.script_sig)) > 0) || len(tx_in.witness) > 0 {
+			return nil, fmt.Errorf("input %v is already signed", i)
+		}
+	}
+	p := &PSBT{tx: unsigned_copy(tx)}
+	p.inputs = make([]PSBTInput, len(tx.tx_ins))
+	p.outputs = make([]PSBTOutput, len(tx.tx_outs))
+	return p, nil
+}
+
+// unsigned_copy keeps only what a PSBT's unsigned transaction holds.
+func unsigned_copy(tx Tx) Tx {
+	out := Tx{version: tx.version, locktime: tx.locktime}
+	for _, tx_in := range tx.tx_ins {
+		out.tx_ins = append(out.tx_ins, TxIn{
+			prev_tx:    tx_in.prev_tx,
+			prev_index: tx_in.prev_index,
+			script_sig: ByteScript{cmds: []byte{}},
+			sequence:   tx_in.sequence,
+		})
+	}
+	out.tx_outs = append(out.tx_outs, tx.tx_outs...)
+	return out
+}
+
+func (p *PSBT) input(index int) (*PSBTInput, error) {
+	if index < 0 || index >= len(p.inputs) {
+		return nil, fmt.Errorf("psbt has no input %v", index)
+	}
+	return &p.inputs[index], nil
+}
+
+func (p *PSBT) output(index int) (*PSBTOutput, error) {
+	if index < 0 || index >= len(p.outputs) {
+		return nil, fmt.Errorf("psbt has no output %v", index)
+	}
+	return &p.outputs[index], nil
+}
+
+// The updater role: the methods below attach what signers and the finalizer
+// need to know about inputs and outputs.
+
+// AddNonWitnessUTXO attaches the whole transaction that input index spends
+// from. Legacy inputs need it: their signatures do not commit to the amount,
+// so only the full transaction proves what is being spent.
+func (p *PSBT) AddNonWitnessUTXO(index int, prev Tx) error {
+	in, err := p.input(index)
+	if err != nil {
+		return err
+	}
+	tx_in := p.tx.tx_ins[index]
+	if prev.TxID() != tx_in.prev_tx || tx_in.prev_index >= len(prev.tx_outs) {
+		return fmt.Errorf("transaction %s does not have output %s:%v", prev.TxID(), tx_in.prev_tx, tx_in.prev_index)
+	}
+	in.non_witness_utxo = &prev
+	return nil
+}
+
+// AddWitnessUTXO attaches the output that segwit input index spends.
+func (p *PSBT) AddWitnessUTXO(index int, out TxOut) error {
+	in, err := p.input(index)
+	if err != nil {
+		return err
+	}
+	in.witness_utxo = &out
+	return nil
+}
+
+func (p *PSBT) SetRedeemScript(index int, script Script) error {
+	in, err := p.input(index)
+	if err != nil {
+		return err
+	}
+	in.redeem_script = script_body(script)
+	return nil
+}
+
+func (p *PSBT) SetWitnessScript(index int, script Script) error {
+	in, err := p.input(index)
+	if err != nil {
+		return err
+	}
+	in.witness_script = script_body(script)
+	return nil
+}
+
+func (p *PSBT) SetSighashType(index int, sighash_type uint32) error {
+	in, err := p.input(index)
+	if err != nil {
+		return err
+	}
+	in.sighash_type = sighash_type
+	return nil
+}
+
+func (p *PSBT) AddInputDerivation(index int, d Bip32Derivation) error {
+	in, err := p.input(index)
+	if err != nil {
+		return err
+	}
+	in.bip32 = append(in.bip32, d)
+	return nil
+}
+
+func (p *PSBT) SetOutputRedeemScript(index int, script Script) error {
+	out, err := p.output(index)
+	if err != nil {
+		return err
+	}
+	out.redeem_script = script_body(script)
+	return nil
+}
+
+func (p *PSBT) SetOutputWitnessScript(index int, script Script) error {
+	out, err := p.output(index)
+	if err != nil {
+		return err
+	}
+	out.witness_script = script_body(script)
+	return nil
+}
+
+func (p *PSBT) AddOutputDerivation(index int, d Bip32Derivation) error {
+	out, err := p.output(index)
+	if err != nil {
+		return err
+	}
+	out.bip32 = append(out.bip32, d)
+	return nil
+}
+
+// spent_output returns the output input index spends, from the witness UTXO
+// or the non-witness one.
+func (p *PSBT) spent_output(index int) (TxOut, bool) {
+	in := p.inputs[index]
+	if in.witness_utxo != nil {
+		return *in.witness_utxo, true
+	}
+	if in.non_witness_utxo != nil {
+		return in.non_witness_utxo.tx_outs[p.tx.tx_ins[index].prev_index], true
+	}
+	return TxOut{}, false
+}
+
+// signing_tx is the unsigned transaction with what is known about the spent
+// outputs filled into its inputs, ready for SigMessage and VerifyInput.
+func (p *PSBT) signing_tx() (Tx, error) {
+	tx := unsigned_copy(p.tx)
+	locktime, err := p.locktime()
+	if err != nil {
+		return Tx{}, err
+	}
+	tx.locktime = locktime
+	for i := range tx.tx_ins {
+		tx_in := &tx.tx_ins[i]
+		in := p.inputs[i]
+		if out, ok := p.spent_output(i); ok {
+			tx_in.prev_tx_script_pubkey = out.script_pubkey
+			tx_in.prev_amount = out.amount
+		}
+		if in.redeem_script != nil {
+			tx_in.redeem_script = ByteScript{cmds: in.redeem_script}
+		}
+		if in.witness_script != nil {
+			tx_in.witness_script = ByteScript{cmds: in.witness_script}
+		}
+	}
+	return tx, nil
+}
+
+func (in PSBTInput) is_final() bool {
+	return in.final_script_sig != nil || in.final_script_witness != nil
+}
+
+func (in PSBTInput) partial_sig(pubkey []byte) []byte {
+	for _, kv := range in.partial_sigs {
+		if bytes.Equal(kv.key, pubkey) {
+			return kv.value
+		}
+	}
+	return nil
+}
+
+// Sign is the signer role: it adds a signature made with secret_key to every
+// input whose script involves the key, and returns how many it signed.
+// Inputs spending legacy outputs are only signed if the whole previous
+// transaction is attached, and only SIGHASH_ALL is supported.
+func (p *PSBT) Sign(secret_key *big.Int, gen Generator) (int, error) {
+	pubkey := PublicKey{gen.base_multiply(secret_key)}.encode(true, false)
+	tx, err := p.signing_tx()
+	if err != nil {
+		return 0, err
+	}
+	signed := 0
+	for i := range p.inputs {
+		in := &p.inputs[i]
+		if in.is_final() || in.partial_sig(pubkey) != nil {
+			continue
+		}
+		if _, ok := p.spent_output(i); !ok {
+			continue
+		}
+		code, segwit, err := tx.script_code(i)
+		if err != nil {
+			return signed, fmt.Errorf("input %v: %v", i, err)
+		}
+		if !bytes.Contains(code, pubkey) && !bytes.Contains(code, Hash160(pubkey)) {
+			continue
+		}
+		if !segwit && in.non_witness_utxo == nil {
+			return signed, fmt.Errorf("input %v: signing a legacy input needs the previous transaction", i)
+		}
+		if in.sighash_type != 0 && in.sighash_type != SIGHASH_ALL {
+			return signed, fmt.Errorf("input %v: sighash type %#x is not supported", i, in.sighash_type)
+		}
+		message, err := tx.SigMessage(i)
+		if err != nil {
+			return signed, fmt.Errorf("input %v: %v", i, err)
+		}
+		sig, err := ecdsa_sign(secret_key, gen, message)
+		if err != nil {
+			return signed, fmt.Errorf("input %v: %v", i, err)
+		}
+		in.partial_sigs = append(in.partial_sigs, psbt_kv{key: pubkey, value: append(sig.sig_encode(), SIGHASH_ALL)})
+		signed++
+	}
+	if p.version == 2 && signed > 0 {
+		// A SIGHASH_ALL signature commits to all inputs and outputs.
+		p.modifiable &^= psbt_inputs_modifiable | psbt_outputs_modifiable
+	}
+	return signed, nil
+}
+
+// CombinePSBT is the combiner role: it merges what several copies of the
+// same PSBT, each possibly updated or signed by someone else, know.
+func CombinePSBT(psbts ...*PSBT) (*PSBT, error) {
+	if len(psbts) == 0 {
+		return nil, fmt.Errorf("no psbts to combine")
+	}
+	global := psbts[0].global_pairs()
+	inputs := make([][]psbt_kv, len(psbts[0].inputs))
+	outputs := make([][]psbt_kv, len(psbts[0].outputs))
+	txid := psbts[0].tx.TxID()
+	for _, p := range psbts {
+		if p.tx.TxID() != txid {
+			return nil, fmt.Errorf("psbts are for different transactions, %s and %s", txid, p.tx.TxID())
+		}
+		global = merge_pairs(global, p.global_pairs())
+		for i := range p.inputs {
+			inputs[i] = merge_pairs(inputs[i], p.input_pairs(i))
+		}
+		for i := range p.outputs {
+			outputs[i] = merge_pairs(outputs[i], p.output_pairs(i))
+		}
+	}
+	out, err := psbt_from_maps(global, inputs, outputs)
+	if err != nil {
+		return nil, err
+	}
+	// Inputs or outputs stay modifiable only if no copy was signed.
+	for _, p := range psbts {
+		out.modifiable &= p.modifiable | psbt_has_sighash_single
+		out.modifiable |= p.modifiable & psbt_has_sighash_single
+	}
+	return out, nil
+}
+
+// merge_pairs adds the entries of b whose keys are not in a.
+func merge_pairs(a, b []psbt_kv) []psbt_kv {
+	out := append([]psbt_kv(nil), a...)
+	for _, kv := range b {
+		found := false
+		for _, have := range a {
+			found = found || bytes.Equal(have.key, kv.key)
+		}
+		if !found {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
+// Finalize is the finalizer role: for every input with enough signatures it
+// builds the final scriptSig and witness, and drops what only signers
+// needed. P2PK, P2PKH, P2WPKH and multisig, bare, in P2SH, P2WSH or
+// P2SH-P2WSH, are supported. Each finalized input is checked with the
+// script interpreter. It returns an error naming the first input that
+// cannot be finalized yet.
+func (p *PSBT) Finalize(gen Generator) error {
+	tx, err := p.signing_tx()
+	if err != nil {
+		return err
+	}
+	var first_err error
+	for i := range p.inputs {
+		in := &p.inputs[i]
+		if in.is_final() {
+			continue
+		}
+		script_sig, witness, err := p.finalize_input(i, tx)
+		if err == nil {
+			check := tx
+			check.tx_ins = append([]TxIn(nil), tx.tx_ins...)
+			check.tx_ins[i].script_sig = ByteScript{cmds: script_sig}
+			check.tx_ins[i].witness = witness
+			err = check.VerifyInput(i, gen)
+		}
+		if err != nil {
+			if first_err == nil {
+				first_err = fmt.Errorf("input %v: %v", i, err)
+			}
+			continue
+		}
+		*in = PSBTInput{
+			non_witness_utxo:     in.non_witness_utxo,
+			witness_utxo:         in.witness_utxo,
+			final_script_sig:     script_sig,
+			final_script_witness: witness,
+			unknown:              in.unknown,
+			required_time:        in.required_time,
+			required_height:      in.required_height,
+		}
+		if in.final_script_sig == nil {
+			in.final_script_sig = []byte{}
+		}
+	}
+	return first_err
+}
+
+// finalize_input works out the scriptSig and witness of input i.
+func (p *PSBT) finalize_input(i int, tx Tx) ([]byte, [][]byte, error) {
+	in := p.inputs[i]
+	out, ok := p.spent_output(i)
+	if !ok {
+		return nil, nil, fmt.Errorf("spent output is not known")
+	}
+	script := script_body(out.script_pubkey)
+	var redeem []byte
+	if Classify(out.script_pubkey).Class == ScriptHashClass {
+		if in.redeem_script == nil {
+			return nil, nil, fmt.Errorf("redeem script is missing")
+		}
+		redeem = in.redeem_script
+		script = redeem
+	}
+	segwit := false
+	if _, _, ok := parse_witness_program(script); ok {
+		segwit = true
+		tmpl := Classify(ByteScript{cmds: script})
+		if tmpl.Class == WitnessPubKeyHashClass {
+			script = P2PKH(tmpl.Hash).cmds
+		} else if tmpl.Class == WitnessScriptHashClass && in.witness_script != nil {
+			script = in.witness_script
+		} else {
+			return nil, nil, fmt.Errorf("cannot finalize a %v output", tmpl.Class)
+		}
+	}
+	stack, err := in.satisfy(script)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Where the stack goes depends on how the script is wrapped.
+	var script_sig []byte
+	var witness [][]byte
+	if segwit {
+		witness = stack
+		if Classify(ByteScript{cmds: script}).Class != PubKeyHashClass || in.witness_script != nil {
+			witness = append(witness, script)
+		}
+	} else {
+		for _, item := range stack {
+			script_sig = append(script_sig, push_data(item)...)
+		}
+	}
+	if redeem != nil {
+		script_sig = append(script_sig, push_data(redeem)...)
+	}
+	return script_sig, witness, nil
+}
+
+// satisfy returns the stack items that satisfy script with the partial
+// signatures collected.
+func (in PSBTInput) satisfy(script []byte) ([][]byte, error) {
+	tmpl := Classify(ByteScript{cmds: script})
+	switch tmpl.Class {
+	case PubKeyClass:
+		if sig := in.partial_sig(tmpl.PubKeys[0]); sig != nil {
+			return [][]byte{sig}, nil
+		}
+	case PubKeyHashClass:
+		for _, kv := range in.partial_sigs {
+			if bytes.Equal(Hash160(kv.key), tmpl.Hash) {
+				return [][]byte{kv.value, kv.key}, nil
+			}
+		}
+	case MultisigClass:
+		// The dummy element, then signatures in the order of the keys.
+		stack := [][]byte{{}}
+		for _, pk := range tmpl.PubKeys {
+			if sig := in.partial_sig(pk); sig != nil && len(stack) <= tmpl.Required {
+				stack = append(stack, sig)
+			}
+		}
+		if len(stack)-1 == tmpl.Required {
+			return stack, nil
+		}
+		return nil, fmt.Errorf("have %v of the %v signatures needed", len(stack)-1, tmpl.Required)
+	default:
+		return nil, fmt.Errorf("cannot finalize a %v script", tmpl.Class)
+	}
+	return nil, fmt.Errorf("signature is missing")
+}
+
+// Extract is the extractor role: it returns the signed transaction once
+// every input is finalized. The inputs also carry the spent outputs, so the
+// result can be checked with VerifyInput.
+func (p *PSBT) Extract() (Tx, error) {
+	tx, err := p.signing_tx()
+	if err != nil {
+		return Tx{}, err
+	}
+	for i, in := range p.inputs {
+		if !in.is_final() {
+			return Tx{}, fmt.Errorf("input %v is not finalized", i)
+		}
+		tx.tx_ins[i].script_sig = ByteScript{cmds: in.final_script_sig}
+		tx.tx_ins[i].witness = in.final_script_witness
+	}
+	return tx, nil
+}
+
+// Serialization. Each map is a list of <key length> <key> <value length>
+// <value> entries ended by a 0x00 byte. Keys start with their type;
+// entries of unknown types are kept as they are.
+
+func (p *PSBT) Serialize() []byte {
+	out := append([]byte(nil), psbt_magic...)
+	out = append(out, encode_pairs(p.global_pairs())...)
+	for i := range p.inputs {
+		out = append(out, encode_pairs(p.input_pairs(i))...)
+	}
+	for i := range p.outputs {
+		out = append(out, encode_pairs(p.output_pairs(i))...)
+	}
+	return out
+}
+
+func encode_pairs(pairs []psbt_kv) []byte {
+	var out []byte
+	for _, kv := range pairs {
+		out = append(out, encode_varint(uint64(len(kv.key)))...)
+		out = append(out, kv.key...)
+		out = append(out, encode_varint(uint64(len(kv.value)))...)
+		out = append(out, kv.value...)
+	}
+	return append(out, 0x00)
+}
+
+func u32_le(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, n)
+	return b
+}
+
+func (d Bip32Derivation) value() []byte {
+	out := append([]byte(nil), d.Fingerprint[:]...)
+	for _, step := range d.Path {
+		out = append(out, u32_le(step)...)
+	}
+	return out
+}
+
+func derivation_pairs(key_type byte, ds []Bip32Derivation) []psbt_kv {
+	var out []psbt_kv
+	for _, d := range ds {
+		out = append(out, psbt_kv{append([]byte{key_type}, d.PubKey...), d.value()})
+	}
+	return out
+}
+
+func (p *PSBT) global_pairs() []psbt_kv {
+	var pairs []psbt_kv
+	if p.version == 2 {
+		pairs = p.v2_global_pairs()
+	} else {
+		pairs = []psbt_kv{{[]byte{psbt_global_unsigned_tx}, p.tx.encode(-1, false)}}
+	}
+	pairs = append(pairs, p.xpubs...)
+	if p.version == 2 {
+		pairs = append(pairs, psbt_kv{[]byte{psbt_global_version}, u32_le(2)})
+	}
+	return append(pairs, p.unknown...)
+}
+
+func (p *PSBT) input_pairs(index int) []psbt_kv {
+	if p.version == 2 {
+		return insert_pairs(p.inputs[index].pairs(), p.v2_input_pairs(index))
+	}
+	return p.inputs[index].pairs()
+}
+
+func (p *PSBT) output_pairs(index int) []psbt_kv {
+	if p.version == 2 {
+		return insert_pairs(p.outputs[index].pairs(), p.v2_output_pairs(index))
+	}
+	return p.outputs[index].pairs()
+}
+
+func (in PSBTInput) pairs() []psbt_kv {
+	var pairs []psbt_kv
+	add := func(key_type byte, value []byte) {
+		pairs = append(pairs, psbt_kv{[]byte{key_type}, value})
+	}
+	if in.non_witness_utxo != nil {
+		add(psbt_in_non_witness_utxo, in.non_witness_utxo.TxEncode(-1))
+	}
+	if in.witness_utxo != nil {
+		add(psbt_in_witness_utxo, in.witness_utxo.txout_encode())
+	}
+	for _, kv := range in.partial_sigs {
+		pairs = append(pairs, psbt_kv{append([]byte{psbt_in_partial_sig}, kv.key...), kv.value})
+	}
+	if in.sighash_type != 0 {
+		add(psbt_in_sighash_type, u32_le(in.sighash_type))
+	}
+	if in.redeem_script != nil {
+		add(psbt_in_redeem_script, in.redeem_script)
+	}
+	if in.witness_script != nil {
+		add(psbt_in_witness_script, in.witness_script)
+	}
+	pairs = append(pairs, derivation_pairs(psbt_in_bip32_derivation, in.bip32)...)
+	if in.final_script_sig != nil {
+		add(psbt_in_final_script_sig, in.final_script_sig)
+	}
+	if in.final_script_witness != nil {
+		add(psbt_in_final_script_witness, encode_witness(in.final_script_witness))
+	}
+	if in.required_time != 0 {
+		add(psbt_in_required_time_locktime, u32_le(in.required_time))
+	}
+	if in.required_height != 0 {
+		add(psbt_in_required_height_locktime, u32_le(in.required_height))
+	}
+	return append(pairs, in.unknown...)
+}
+
+func (o PSBTOutput) pairs() []psbt_kv {
+	var pairs []psbt_kv
+	if o.redeem_script != nil {
+		pairs = append(pairs, psbt_kv{[]byte{psbt_out_redeem_script}, o.redeem_script})
+	}
+	if o.witness_script != nil {
+		pairs = append(pairs, psbt_kv{[]byte{psbt_out_witness_script}, o.witness_script})
+	}
+	pairs = append(pairs, derivation_pairs(psbt_out_bip32_derivation, o.bip32)...)
+	return append(pairs, o.unknown...)
+}
+
+// Base64 is the text form of a PSBT, as wallets and bitcoind exchange it.
+func (p *PSBT) Base64() string {
+	return base64.StdEncoding.EncodeToString(p.Serialize())
+}
+
+func ParsePSBTBase64(s string) (*PSBT, error) {
+	b, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace([]byte(s))))
+	if err != nil {
+		return nil, fmt.Errorf("psbt: %v", err)
+	}
+	return ParsePSBT(b)
+}
+
+// ReadPSBTFile reads a PSBT file, binary or base64.
+func ReadPSBTFile(path string) (*PSBT, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(b, psbt_magic) {
+		return ParsePSBT(b)
+	}
+	return ParsePSBTBase64(string(b))
+}
+
+// WriteFile saves the PSBT to path, in binary as .psbt files usually are,
+// or as base64 text.
+func (p *PSBT) WriteFile(path string, as_base64 bool) error {
+	if as_base64 {
+		return os.WriteFile(path, []byte(p.Base64()+"\n"), 0644)
+	}
+	return os.WriteFile(path, p.Serialize(), 0644)
+}
+
+// ParsePSBT parses a binary PSBT.
+func ParsePSBT(b []byte) (*PSBT, error) {
+	if !bytes.HasPrefix(b, psbt_magic) {
+		return nil, fmt.Errorf("psbt: missing magic bytes")
+	}
+	r := &reader{b: b, off: len(psbt_magic)}
+	global, err := r.psbt_map()
+	if err != nil {
+		return nil, err
+	}
+	n_in, n_out, err := psbt_counts(global)
+	if err != nil {
+		return nil, err
+	}
+	// Every map takes at least its terminator.
+	if n_in+n_out > len(b)-r.off {
+		return nil, fmt.Errorf("psbt: %v maps do not fit in %v bytes", n_in+n_out, len(b)-r.off)
+	}
+	inputs := make([][]psbt_kv, n_in)
+	for i := range inputs {
+		if inputs[i], err = r.psbt_map(); err != nil {
+			return nil, fmt.Errorf("psbt: input %v: %v", i, err)
+		}
+	}
+	outputs := make([][]psbt_kv, n_out)
+	for i := range outputs {
+		if outputs[i], err = r.psbt_map(); err != nil {
+			return nil, fmt.Errorf("psbt: output %v: %v", i, err)
+		}
+	}
+	if err := r.done(); err != nil {
+		return nil, fmt.Errorf("psbt: %v", err)
+	}
+	return psbt_from_maps(global, inputs, outputs)
+}
+
+// psbt_counts returns how many input and output maps follow the global one.
+func psbt_counts(global []psbt_kv) (int, int, error) {
+	for _, kv := range global {
+		if kv.key[0] == psbt_global_version && len(kv.value) == 4 && binary.LittleEndian.Uint32(kv.value) == 2 {
+			return v2_counts(global)
+		}
+	}
+	for _, kv := range global {
+		if kv.key[0] == psbt_global_unsigned_tx {
+			r := &reader{b: kv.value}
+			tx := r.tx(false)
+			if err := r.done(); err != nil {
+				return 0, 0, fmt.Errorf("psbt: unsigned transaction: %v", err)
+			}
+			return len(tx.tx_ins), len(tx.tx_outs), nil
+		}
+	}
+	return 0, 0, fmt.Errorf("psbt: no unsigned transaction")
+}
+
+// psbt_map reads one map, up to and including its 0x00 terminator.
+func (r *reader) psbt_map() ([]psbt_kv, error) {
+	var pairs []psbt_kv
+	seen := make(map[string]bool)
+	for {
+		key := r.var_bytes()
+		if r.err != nil {
+			return nil, r.err
+		}
+		if len(key) == 0 {
+			return pairs, nil
+		}
+		value := r.var_bytes()
+		if r.err != nil {
+			return nil, r.err
+		}
+		if seen[string(key)] {
+			return nil, fmt.Errorf("duplicate key %x", key)
+		}
+		seen[string(key)] = true
+		pairs = append(pairs, psbt_kv{key, value})
+	}
+}
+
+func psbt_from_maps(global []psbt_kv, inputs, outputs [][]psbt_kv) (*PSBT, error) {
+	p := &PSBT{}
+	has_tx := false
+	var v2_global []psbt_kv
+	for _, kv := range global {
+		switch kv.key[0] {
+		case psbt_global_unsigned_tx:
+			if len(kv.key) != 1 {
+				return nil, fmt.Errorf("psbt: unsigned transaction key has data")
+			}
+			r := &reader{b: kv.value}
+			p.tx = r.tx(false)
+			if err := r.done(); err != nil {
+				return nil, fmt.Errorf("psbt: unsigned transaction: %v", err)
+			}
+			has_tx = true
+		case psbt_global_xpub:
+			if len(kv.key) != 79 || len(kv.value)%4 != 0 || len(kv.value) == 0 {
+				return nil, fmt.Errorf("psbt: malformed xpub entry")
+			}
+			p.xpubs = append(p.xpubs, kv)
+		case psbt_global_version:
+			if len(kv.key) != 1 || len(kv.value) != 4 {
+				return nil, fmt.Errorf("psbt: malformed version entry")
+			}
+			switch v := binary.LittleEndian.Uint32(kv.value); v {
+			case 0:
+				p.unknown = append(p.unknown, kv)
+			case 2:
+				p.version = 2
+			default:
+				return nil, fmt.Errorf("psbt: version %v is not supported", v)
+			}
+		case psbt_global_tx_version, psbt_global_fallback_locktime, psbt_global_input_count,
+			psbt_global_output_count, psbt_global_tx_modifiable:
+			if len(kv.key) != 1 {
+				p.unknown = append(p.unknown, kv)
+				break
+			}
+			v2_global = append(v2_global, kv)
+		default:
+			p.unknown = append(p.unknown, kv)
+		}
+	}
+	if p.version == 2 {
+		if has_tx {
+			return nil, fmt.Errorf("psbt: version 2 must not have an unsigned transaction")
+		}
+		var err error
+		if inputs, outputs, err = p.v2_from_maps(v2_global, inputs, outputs); err != nil {
+			return nil, fmt.Errorf("psbt: %v", err)
+		}
+	} else if err := check_no_v2_fields(v2_global, inputs, outputs); err != nil {
+		return nil, fmt.Errorf("psbt: %v", err)
+	} else if !has_tx {
+		return nil, fmt.Errorf("psbt: no unsigned transaction")
+	}
+	for i, tx_in := range p.tx.tx_ins {
+		if len(script_body(tx_in.script_sig)) > 0 || len(tx_in.witness) > 0 {
+			return nil, fmt.Errorf("psbt: input %v of the unsigned transaction has a scriptSig", i)
+		}
+	}
+	if len(inputs) != len(p.tx.tx_ins) || len(outputs) != len(p.tx.tx_outs) {
+		return nil, fmt.Errorf("psbt: map counts do not match the transaction")
+	}
+	p.inputs = make([]PSBTInput, len(inputs))
+	for i, pairs := range inputs {
+		in, err := parse_psbt_input(pairs)
+		if err != nil {
+			return nil, fmt.Errorf("psbt: input %v: %v", i, err)
+		}
+		if in.non_witness_utxo != nil {
+			tx_in := p.tx.tx_ins[i]
+			if in.non_witness_utxo.TxID() != tx_in.prev_tx || tx_in.prev_index >= len(in.non_witness_utxo.tx_outs) {
+				return nil, fmt.Errorf("psbt: input %v: previous transaction does not match the outpoint", i)
+			}
+		}
+		p.inputs[i] = in
+	}
+	p.outputs = make([]PSBTOutput, len(outputs))
+	for i, pairs := range outputs {
+		out, err := parse_psbt_output(pairs)
+		if err != nil {
+			return nil, fmt.Errorf("psbt: output %v: %v", i, err)
+		}
+		p.outputs[i] = out
+	}
+	return p, nil
+}
+
+func parse_derivation(kv psbt_kv) (Bip32Derivation, error) {
+	pubkey := kv.key[1:]
+	if !is_pubkey(pubkey) {
+		return Bip32Derivation{}, fmt.Errorf("invalid public key %x in derivation", pubkey)
+	}
+	if len(kv.value) < 4 || len(kv.value)%4 != 0 {
+		return Bip32Derivation{}, fmt.Errorf("malformed derivation path")
+	}
+	d := Bip32Derivation{PubKey: pubkey}
+	copy(d.Fingerprint[:], kv.value)
+	for i := 4; i < len(kv.value); i += 4 {
+		d.Path = append(d.Path, binary.LittleEndian.Uint32(kv.value[i:]))
+	}
+	return d, nil
+}
+
+func parse_psbt_input(pairs []psbt_kv) (PSBTInput, error) {
+	var in PSBTInput
+	for _, kv := range pairs {
+		key_type := kv.key[0]
+		if psbt_in_keyless[key_type] && len(kv.key) != 1 {
+			return in, fmt.Errorf("key of type %#x has data", key_type)
+		}
+		if len(kv.key) != 1 && (key_type == psbt_in_required_time_locktime || key_type == psbt_in_required_height_locktime) {
+			in.unknown = append(in.unknown, kv)
+			continue
+		}
+		switch key_type {
+		case psbt_in_non_witness_utxo:
+			tx, err := ParseTx(kv.value)
+			if err != nil {
+				return in, fmt.Errorf("non-witness utxo: %v", err)
+			}
+			in.non_witness_utxo = &tx
+		case psbt_in_witness_utxo:
+			r := &reader{b: kv.value}
+			out := TxOut{amount: int64(r.u64()), script_pubkey: ByteScript{cmds: r.var_bytes()}}
+			if err := r.done(); err != nil {
+				return in, fmt.Errorf("witness utxo: %v", err)
+			}
+			in.witness_utxo = &out
+		case psbt_in_partial_sig:
+			if !is_pubkey(kv.key[1:]) {
+				return in, fmt.Errorf("invalid public key %x in partial signature", kv.key[1:])
+			}
+			in.partial_sigs = append(in.partial_sigs, psbt_kv{kv.key[1:], kv.value})
+		case psbt_in_sighash_type:
+			if len(kv.value) != 4 {
+				return in, fmt.Errorf("malformed sighash type")
+			}
+			in.sighash_type = binary.LittleEndian.Uint32(kv.value)
+		case psbt_in_redeem_script:
+			in.redeem_script = kv.value
+		case psbt_in_witness_script:
+			in.witness_script = kv.value
+		case psbt_in_bip32_derivation:
+			d, err := parse_derivation(kv)
+			if err != nil {
+				return in, err
+			}
+			in.bip32 = append(in.bip32, d)
+		case psbt_in_final_script_sig:
+			in.final_script_sig = kv.value
+		case psbt_in_final_script_witness:
+			r := &reader{b: kv.value}
+			in.final_script_witness = r.witness()
+			if err := r.done(); err != nil {
+				return in, fmt.Errorf("final witness: %v", err)
+			}
+			if in.final_script_witness == nil {
+				in.final_script_witness = [][]byte{}
+			}
+		case psbt_in_required_time_locktime:
+			if len(kv.value) != 4 {
+				return in, fmt.Errorf("malformed required time locktime")
+			}
+			if in.required_time = binary.LittleEndian.Uint32(kv.value); in.required_time < locktime_threshold {
+				return in, fmt.Errorf("required time locktime %v is a height", in.required_time)
+			}
+		case psbt_in_required_height_locktime:
+			if len(kv.value) != 4 {
+				return in, fmt.Errorf("malformed required height locktime")
+			}
+			in.required_height = binary.LittleEndian.Uint32(kv.value)
+			if in.required_height == 0 || in.required_height >= locktime_threshold {
+				return in, fmt.Errorf("required height locktime %v is not a height", in.required_height)
+			}
+		default:
+			in.unknown = append(in.unknown, kv)
+		}
+	}
+	return in, nil
+}
+
+func parse_psbt_output(pairs []psbt_kv) (PSBTOutput, error) {
+	var out PSBTOutput
+	for _, kv := range pairs {
+		key_type := kv.key[0]
+		if key_type != psbt_out_bip32_derivation && key_type <= psbt_out_bip32_derivation && len(kv.key) != 1 {
+			return out, fmt.Errorf("key of type %#x has data", key_type)
+		}
+		switch key_type {
+		case psbt_out_redeem_script:
+			out.redeem_script = kv.value
+		case psbt_out_witness_script:
+			out.witness_script = kv.value
+		case psbt_out_bip32_derivation:
+			d, err := parse_derivation(kv)
+			if err != nil {
+				return out, err
+			}
+			out.bip32 = append(out.bip32, d)
+		default:
+			out.unknown = append(out.unknown, kv)
+		}
+	}
+	return out, nil
+}
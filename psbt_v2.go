@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// PSBT version 2 (BIP370). Instead of one unsigned transaction in the global
+// map, the transaction's fields are spread over the maps: version, locktime
+// and counts in the global map, outpoints and sequences in the input maps,
+// amounts and scripts in the output maps. That lets inputs and outputs be
+// added after the PSBT is created, while the TX_MODIFIABLE flags allow it.
+
+const (
+	psbt_global_tx_version        = 0x02
+	psbt_global_fallback_locktime = 0x03
+	psbt_global_input_count       = 0x04
+	psbt_global_output_count      = 0x05
+	psbt_global_tx_modifiable     = 0x06
+
+	psbt_in_previous_txid            = 0x0e
+	psbt_in_output_index             = 0x0f
+	psbt_in_sequence                 = 0x10
+	psbt_in_required_time_locktime   = 0x11
+	psbt_in_required_height_locktime = 0x12
+
+	psbt_out_amount = 0x03
+	psbt_out_script = 0x04
+)
+
+// Bits of the TX_MODIFIABLE field.
+const (
+	psbt_inputs_modifiable  = 0x01
+	psbt_outputs_modifiable = 0x02
+	psbt_has_sighash_single = 0x04
+)
+
+// NewPSBTv2 creates an empty version 2 PSBT whose inputs and outputs can be
+// added to. fallback_locktime is the locktime used if no input requires one.
+func NewPSBTv2(tx_version uint32, fallback_locktime uint32) (*PSBT, error) {
+	if tx_version < 2 {
+		return nil, fmt.Errorf("version 2 psbts need transaction version 2 or more, got %v", tx_version)
+	}
+	return &PSBT{
+		version:      2,
+		tx:           Tx{version: tx_version, locktime: fallback_locktime},
+		modifiable:   psbt_inputs_modifiable | psbt_outputs_modifiable,
+		has_fallback: true,
+	}, nil
+}
+
+// Version is the PSBT version, 0 or 2.
+func (p *PSBT) Version() int {
+	return int(p.version)
+}
+
+// ConvertVersion turns the PSBT into a version 0 or a version 2 one, as
+// BIP370 describes. Going to version 0 fixes the transaction's locktime and
+// drops what only version 2 can say: the locktimes inputs require and the
+// TX_MODIFIABLE flags. Going to version 2 needs a transaction version of 2
+// or more; a locktime other than 0 becomes the fallback locktime and, the
+// transaction having been fixed, nothing is modifiable.
+func (p *PSBT) ConvertVersion(version int) error {
+	switch version {
+	case p.Version():
+		return nil
+	case 0:
+		locktime, err := p.locktime()
+		if err != nil {
+			return err
+		}
+		p.tx.locktime = locktime
+		for i := range p.inputs {
+			p.inputs[i].required_time, p.inputs[i].required_height = 0, 0
+		}
+	case 2:
+		if p.tx.version < 2 {
+			return fmt.Errorf("version 2 psbts need transaction version 2 or more, got %v", p.tx.version)
+		}
+		// An explicit version 0 field is kept with the unknown entries.
+		var unknown []psbt_kv
+		for _, kv := range p.unknown {
+			if len(kv.key) != 1 || kv.key[0] != psbt_global_version {
+				unknown = append(unknown, kv)
+			}
+		}
+		p.unknown = unknown
+		p.has_fallback = p.tx.locktime != 0
+	default:
+		return fmt.Errorf("psbt version %v is not supported", version)
+	}
+	p.version = uint32(version)
+	p.modifiable = 0
+	return nil
+}
+
+// AddInput adds an input spending prev_tx:prev_index, with no locktime
+// requirement. Its UTXO and scripts are attached with the updater methods.
+func (p *PSBT) AddInput(prev_tx Hash, prev_index int, sequence uint32) error {
+	if p.version != 2 || p.modifiable&psbt_inputs_modifiable == 0 {
+		return fmt.Errorf("psbt inputs cannot be modified")
+	}
+	for _, tx_in := range p.tx.tx_ins {
+		if tx_in.prev_tx == prev_tx && tx_in.prev_index == prev_index {
+			return fmt.Errorf("psbt already spends %s:%v", prev_tx, prev_index)
+		}
+	}
+	tx_in := NewTxIn(prev_tx, prev_index)
+	tx_in.script_sig = ByteScript{cmds: []byte{}}
+	tx_in.sequence = sequence
+	p.tx.tx_ins = append(p.tx.tx_ins, tx_in)
+	p.inputs = append(p.inputs, PSBTInput{})
+	return nil
+}
+
+// AddOutput adds an output paying amount to script_pubkey.
+func (p *PSBT) AddOutput(amount int64, script_pubkey Script) error {
+	if p.version != 2 || p.modifiable&psbt_outputs_modifiable == 0 {
+		return fmt.Errorf("psbt outputs cannot be modified")
+	}
+	p.tx.tx_outs = append(p.tx.tx_outs, TxOut{amount: amount, script_pubkey: ByteScript{cmds: script_body(script_pubkey)}})
+	p.outputs = append(p.outputs, PSBTOutput{})
+	return nil
+}
+
+// SetRequiredLockTime records that input index needs the transaction's
+// locktime to be at least lock, a height or a time as with LockHeight and
+// LockTime. It fails if that cannot be agreed with the other inputs.
+func (p *PSBT) SetRequiredLockTime(index int, lock uint32) error {
+	in, err := p.input(index)
+	if err != nil {
+		return err
+	}
+	if p.version != 2 || p.modifiable&psbt_inputs_modifiable == 0 {
+		return fmt.Errorf("psbt inputs cannot be modified")
+	}
+	saved := *in
+	if lock < locktime_threshold {
+		in.required_height = lock
+	} else {
+		in.required_time = lock
+	}
+	if _, err := p.locktime(); err != nil {
+		*in = saved
+		return err
+	}
+	return nil
+}
+
+// locktime works out the transaction's locktime. Version 0 PSBTs have it in
+// the unsigned transaction. For version 2 it is the largest locktime the
+// inputs require, by height if every input with a requirement accepts a
+// height and otherwise by time, or the fallback locktime if none do.
+func (p *PSBT) locktime() (uint32, error) {
+	if p.version != 2 {
+		return p.tx.locktime, nil
+	}
+	var height, time uint32
+	required, by_height, by_time := false, true, true
+	for _, in := range p.inputs {
+		if in.required_height == 0 && in.required_time == 0 {
+			continue
+		}
+		required = true
+		by_height = by_height && in.required_height != 0
+		by_time = by_time && in.required_time != 0
+		if in.required_height > height {
+			height = in.required_height
+		}
+		if in.required_time > time {
+			time = in.required_time
+		}
+	}
+	switch {
+	case !required:
+		return p.tx.locktime, nil
+	case by_height:
+		return height, nil
+	case by_time:
+		return time, nil
+	}
+	return 0, fmt.Errorf("psbt inputs require both a height and a time locktime")
+}
+
+func (p *PSBT) v2_global_pairs() []psbt_kv {
+	pairs := []psbt_kv{{[]byte{psbt_global_tx_version}, u32_le(p.tx.version)}}
+	if p.has_fallback {
+		pairs = append(pairs, psbt_kv{[]byte{psbt_global_fallback_locktime}, u32_le(p.tx.locktime)})
+	}
+	pairs = append(pairs,
+		psbt_kv{[]byte{psbt_global_input_count}, encode_varint(uint64(len(p.inputs)))},
+		psbt_kv{[]byte{psbt_global_output_count}, encode_varint(uint64(len(p.outputs)))},
+	)
+	if p.modifiable != 0 {
+		pairs = append(pairs, psbt_kv{[]byte{psbt_global_tx_modifiable}, []byte{p.modifiable}})
+	}
+	return pairs
+}
+
+func (p *PSBT) v2_input_pairs(index int) []psbt_kv {
+	tx_in := p.tx.tx_ins[index]
+	pairs := []psbt_kv{
+		{[]byte{psbt_in_previous_txid}, append([]byte(nil), tx_in.prev_tx[:]...)},
+		{[]byte{psbt_in_output_index}, u32_le(uint32(tx_in.prev_index))},
+	}
+	if tx_in.sequence != SEQUENCE_FINAL {
+		pairs = append(pairs, psbt_kv{[]byte{psbt_in_sequence}, u32_le(tx_in.sequence)})
+	}
+	return pairs
+}
+
+func (p *PSBT) v2_output_pairs(index int) []psbt_kv {
+	tx_out := p.tx.tx_outs[index]
+	amount := make([]byte, 8)
+	binary.LittleEndian.PutUint64(amount, uint64(tx_out.amount))
+	return []psbt_kv{
+		{[]byte{psbt_out_amount}, amount},
+		{[]byte{psbt_out_script}, script_body(tx_out.script_pubkey)},
+	}
+}
+
+// insert_pairs inserts the version 2 fields into pairs, which hold the known
+// fields in key order and then the unknown ones, so that the map comes out
+// in key order as BIP370's test vectors have it.
+func insert_pairs(pairs, fields []psbt_kv) []psbt_kv {
+	i := 0
+	for i < len(pairs) && len(pairs[i].key) > 0 && pairs[i].key[0] < fields[0].key[0] {
+		i++
+	}
+	out := append(append([]psbt_kv(nil), pairs[:i]...), fields...)
+	return append(out, pairs[i:]...)
+}
+
+// v2_counts reads the input and output counts of a version 2 global map.
+func v2_counts(global []psbt_kv) (int, int, error) {
+	n_in, n_out := -1, -1
+	for _, kv := range global {
+		if len(kv.key) != 1 || (kv.key[0] != psbt_global_input_count && kv.key[0] != psbt_global_output_count) {
+			continue
+		}
+		r := &reader{b: kv.value}
+		n := r.varint()
+		if err := r.done(); err != nil || n > 1<<31 {
+			return 0, 0, fmt.Errorf("psbt: malformed count %x", kv.value)
+		}
+		if kv.key[0] == psbt_global_input_count {
+			n_in = int(n)
+		} else {
+			n_out = int(n)
+		}
+	}
+	if n_in < 0 || n_out < 0 {
+		return 0, 0, fmt.Errorf("psbt: version 2 needs input and output counts")
+	}
+	return n_in, n_out, nil
+}
+
+// split_pairs separates the entries whose key is one of types alone.
+func split_pairs(pairs []psbt_kv, types ...byte) (matched, rest []psbt_kv) {
+	for _, kv := range pairs {
+		found := false
+		if len(kv.key) != 1 {
+			rest = append(rest, kv)
+			continue
+		}
+		for _, t := range types {
+			found = found || kv.key[0] == t
+		}
+		if found {
+			matched = append(matched, kv)
+		} else {
+			rest = append(rest, kv)
+		}
+	}
+	return matched, rest
+}
+
+// v2_from_maps builds p.tx from the fields of a version 2 PSBT and returns
+// the input and output maps without them.
+func (p *PSBT) v2_from_maps(global []psbt_kv, inputs, outputs [][]psbt_kv) ([][]psbt_kv, [][]psbt_kv, error) {
+	has_version, n_in, n_out := false, -1, -1
+	for _, kv := range global {
+		r := &reader{b: kv.value}
+		switch kv.key[0] {
+		case psbt_global_tx_version:
+			p.tx.version = r.u32()
+			has_version = true
+		case psbt_global_fallback_locktime:
+			p.tx.locktime = r.u32()
+			p.has_fallback = true
+		case psbt_global_input_count:
+			n_in = int(r.varint())
+		case psbt_global_output_count:
+			n_out = int(r.varint())
+		case psbt_global_tx_modifiable:
+			p.modifiable = r.u8()
+		}
+		if err := r.done(); err != nil {
+			return nil, nil, fmt.Errorf("global field %#x: %v", kv.key[0], err)
+		}
+	}
+	if !has_version || p.tx.version < 2 {
+		return nil, nil, fmt.Errorf("version 2 needs a transaction version of 2 or more")
+	}
+	if n_in != len(inputs) || n_out != len(outputs) {
+		return nil, nil, fmt.Errorf("map counts do not match the global counts")
+	}
+	p.tx.tx_ins = make([]TxIn, len(inputs))
+	for i := range inputs {
+		var fields []psbt_kv
+		fields, inputs[i] = split_pairs(inputs[i], psbt_in_previous_txid, psbt_in_output_index, psbt_in_sequence)
+		tx_in := TxIn{prev_index: -1, script_sig: ByteScript{cmds: []byte{}}, sequence: SEQUENCE_FINAL}
+		has_txid := false
+		for _, kv := range fields {
+			r := &reader{b: kv.value}
+			switch kv.key[0] {
+			case psbt_in_previous_txid:
+				tx_in.prev_tx = r.hash()
+				has_txid = true
+			case psbt_in_output_index:
+				tx_in.prev_index = int(r.u32())
+			case psbt_in_sequence:
+				tx_in.sequence = r.u32()
+			}
+			if err := r.done(); err != nil {
+				return nil, nil, fmt.Errorf("input %v: field %#x: %v", i, kv.key[0], err)
+			}
+		}
+		if !has_txid || tx_in.prev_index < 0 {
+			return nil, nil, fmt.Errorf("input %v: outpoint is missing", i)
+		}
+		p.tx.tx_ins[i] = tx_in
+	}
+	p.tx.tx_outs = make([]TxOut, len(outputs))
+	for i := range outputs {
+		var fields []psbt_kv
+		fields, outputs[i] = split_pairs(outputs[i], psbt_out_amount, psbt_out_script)
+		has_amount, has_script := false, false
+		for _, kv := range fields {
+			if kv.key[0] == psbt_out_amount {
+				r := &reader{b: kv.value}
+				p.tx.tx_outs[i].amount = int64(r.u64())
+				if err := r.done(); err != nil {
+					return nil, nil, fmt.Errorf("output %v: amount: %v", i, err)
+				}
+				has_amount = true
+			} else {
+				p.tx.tx_outs[i].script_pubkey = ByteScript{cmds: kv.value}
+				has_script = true
+			}
+		}
+		if !has_amount || !has_script {
+			return nil, nil, fmt.Errorf("output %v: amount or script is missing", i)
+		}
+	}
+	return inputs, outputs, nil
+}
+
+// check_no_v2_fields rejects a version 0 PSBT holding version 2 fields.
+func check_no_v2_fields(global []psbt_kv, inputs, outputs [][]psbt_kv) error {
+	if len(global) > 0 {
+		return fmt.Errorf("version 0 has global field %#x", global[0].key[0])
+	}
+	for i, pairs := range inputs {
+		if fields, _ := split_pairs(pairs, psbt_in_previous_txid, psbt_in_output_index, psbt_in_sequence,
+			psbt_in_required_time_locktime, psbt_in_required_height_locktime); len(fields) > 0 {
+			return fmt.Errorf("input %v: version 0 has field %#x", i, fields[0].key[0])
+		}
+	}
+	for i, pairs := range outputs {
+		if fields, _ := split_pairs(pairs, psbt_out_amount, psbt_out_script); len(fields) > 0 {
+			return fmt.Errorf("output %v: version 0 has field %#x", i, fields[0].key[0])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Inputs with a sequence at or below max_rbf_sequence signal that the
+// transaction may be replaced (BIP125).
+const max_rbf_sequence = 0xfffffffd
+
+// incremental_relay_fee is the feerate, in sat/vB, by which a replacement
+// must at least pay for its own size on top of what it replaces.
+const incremental_relay_fee = 1
+
+// min_relay_fee is the lowest feerate, in sat/vB, nodes relay a transaction
+// at on its own.
+const min_relay_fee = 1
+
+// max_replaced is the most transactions a single replacement may evict.
+const max_replaced = 100
+
+// Fee is what the inputs spend minus what the outputs pay. It needs the
+// prev_amount of every input.
+func (t Tx) Fee() (int64, error) {
+	var fee int64
+	for i, tx_in := range t.tx_ins {
+		if tx_in.prev_amount <= 0 {
+			return 0, fmt.Errorf("input %v: amount of the spent output is not known", i)
+		}
+		fee += tx_in.prev_amount
+	}
+	for _, tx_out := range t.tx_outs {
+		fee -= tx_out.amount
+	}
+	if fee < 0 {
+		return 0, fmt.Errorf("outputs pay %v more than the inputs spend", -fee)
+	}
+	return fee, nil
+}
+
+// FeeRate returns the fee in sat/vB.
+func (t Tx) FeeRate() (float64, error) {
+	fee, err := t.Fee()
+	if err != nil {
+		return 0, err
+	}
+	return float64(fee) / float64(t.VSize()), nil
+}
+
+// SignalRBF lowers the sequence of every input so the transaction signals
+// replaceability. Sequences already lower, such as relative locks, are kept.
+func (t *Tx) SignalRBF() {
+	for i := range t.tx_ins {
+		if t.tx_ins[i].sequence > max_rbf_sequence {
+			t.tx_ins[i].sequence = max_rbf_sequence
+		}
+	}
+}
+
+// SignalsRBF reports whether the transaction opted in to replacement itself.
+// Transactions inheriting replaceability from unconfirmed parents are not
+// detected: that needs the mempool.
+func (t Tx) SignalsRBF() bool {
+	for _, tx_in := range t.tx_ins {
+		if tx_in.sequence <= max_rbf_sequence {
+			return true
+		}
+	}
+	return false
+}
+
+// dust_change_error is returned by pay_fee_from when paying the fee would
+// leave the output it takes the fee from below the dust limit.
+type dust_change_error struct {
+	fee, change int64
+	index       int
+}
+
+func (e dust_change_error) Error() string {
+	return fmt.Sprintf("a fee of %v leaves %v for output %v, below the dust limit", e.fee, e.change, e.index)
+}
+
+// pay_fee_from sets the amount of output change_index so that tx pays at
+// least want(vsize), calling sign after every change to measure the signed
+// size. Signatures vary in length by a byte or so, so this takes a few
+// rounds at most.
+func pay_fee_from(tx *Tx, change_index int, want func(vsize int) int64, sign func(tx *Tx) error) error {
+	if change_index < 0 || change_index >= len(tx.tx_outs) {
+		return fmt.Errorf("no output %v to take the fee from", change_index)
+	}
+	var in_total, other_outs int64
+	for _, tx_in := range tx.tx_ins {
+		in_total += tx_in.prev_amount
+	}
+	for i, tx_out := range tx.tx_outs {
+		if i != change_index {
+			other_outs += tx_out.amount
+		}
+	}
+	for round := 0; round < 4; round++ {
+		if err := sign(tx); err != nil {
+			return err
+		}
+		fee := in_total - other_outs - tx.tx_outs[change_index].amount
+		target := want(tx.VSize())
+		if fee >= target {
+			return nil
+		}
+		change := in_total - other_outs - target
+		if change < dust_threshold(tx.tx_outs[change_index].script_pubkey) {
+			return dust_change_error{fee: target, change: change, index: change_index}
+		}
+		tx.tx_outs[change_index].amount = change
+	}
+	return fmt.Errorf("fee did not settle")
+}
+
+// ReplaceByFee returns a replacement for original spending the same inputs
+// at feerate sat/vB, paying the extra fee out of output change_index. As
+// BIP125 requires, it pays at least the original fee plus the incremental
+// relay fee for its own size. sign must sign every input of the transaction
+// it is given; the replacement also signals RBF, so it can be bumped again.
+func ReplaceByFee(original Tx, change_index int, feerate float64, sign func(tx *Tx) error) (Tx, error) {
+	if !original.SignalsRBF() {
+		return Tx{}, fmt.Errorf("original transaction does not signal replaceability")
+	}
+	original_fee, err := original.Fee()
+	if err != nil {
+		return Tx{}, err
+	}
+	replacement := original
+	replacement.tx_ins = append([]TxIn(nil), original.tx_ins...)
+	replacement.tx_outs = append([]TxOut(nil), original.tx_outs...)
+	for i := range replacement.tx_ins {
+		replacement.tx_ins[i].script_sig = nil
+		replacement.tx_ins[i].witness = nil
+	}
+	replacement.SignalRBF()
+	want := func(vsize int) int64 {
+		fee := int64(math.Ceil(feerate * float64(vsize)))
+		if min := original_fee + int64(incremental_relay_fee*vsize); fee < min {
+			fee = min
+		}
+		return fee
+	}
+	if err := pay_fee_from(&replacement, change_index, want, sign); err != nil {
+		return Tx{}, err
+	}
+	return replacement, CheckReplacement(replacement, []Tx{original})
+}
+
+// CheckReplacement checks replacement against the BIP125 rules for the
+// transactions it conflicts with. Rule 2, which limits new unconfirmed
+// inputs, needs the mempool and is not checked.
+func CheckReplacement(replacement Tx, originals []Tx) error {
+	if len(originals) > max_replaced {
+		return fmt.Errorf("replacement would evict %v transactions, at most %v allowed", len(originals), max_replaced)
+	}
+	spends := make(map[string]bool)
+	for _, tx_in := range replacement.tx_ins {
+		spends[outpoint_key(tx_in)] = true
+	}
+	var original_fees int64
+	for _, original := range originals {
+		if !original.SignalsRBF() {
+			return fmt.Errorf("original %s does not signal replaceability", original.TxID())
+		}
+		conflicts := false
+		for _, tx_in := range original.tx_ins {
+			conflicts = conflicts || spends[outpoint_key(tx_in)]
+		}
+		if !conflicts {
+			return fmt.Errorf("original %s does not share an input with the replacement", original.TxID())
+		}
+		fee, err := original.Fee()
+		if err != nil {
+			return err
+		}
+		original_fees += fee
+	}
+	fee, err := replacement.Fee()
+	if err != nil {
+		return err
+	}
+	if fee < original_fees {
+		return fmt.Errorf("replacement pays %v, less than the %v it replaces", fee, original_fees)
+	}
+	if min := int64(incremental_relay_fee * replacement.VSize()); fee-original_fees < min {
+		return fmt.Errorf("replacement adds %v in fees, needs at least %v for its size", fee-original_fees, min)
+	}
+	return nil
+}
+
+func outpoint_key(tx_in TxIn) string {
+	return fmt.Sprintf("%s:%v", tx_in.prev_tx, tx_in.prev_index)
+}
+
+// CPFP returns a child of parent that spends its output index (usually our
+// change) to script_pubkey, with a fee large enough that parent and child
+// together pay feerate sat/vB. The child always pays at least the minimum
+// relay fee for its own size, even when the parent alone already pays
+// feerate. sign must sign the child's input.
+func CPFP(parent Tx, index int, script_pubkey Script, feerate float64, sign func(tx *Tx) error) (Tx, error) {
+	if index < 0 || index >= len(parent.tx_outs) {
+		return Tx{}, fmt.Errorf("parent has no output %v", index)
+	}
+	parent_fee, err := parent.Fee()
+	if err != nil {
+		return Tx{}, err
+	}
+	out := parent.tx_outs[index]
+	tx_in := NewTxIn(parent.TxID(), index)
+	tx_in.prev_tx_script_pubkey = out.script_pubkey
+	tx_in.prev_amount = out.amount
+	child := Tx{
+		version: 2,
+		tx_ins:  []TxIn{tx_in},
+		tx_outs: []TxOut{{amount: out.amount, script_pubkey: script_pubkey}},
+	}
+	child.SignalRBF()
+	// The child pays for the whole package, less what the parent already pays.
+	parent_vsize := parent.VSize()
+	want := func(vsize int) int64 {
+		fee := int64(math.Ceil(feerate*float64(parent_vsize+vsize))) - parent_fee
+		if min := fee_for(vsize, min_relay_fee); fee < min {
+			fee = min
+		}
+		return fee
+	}
+	if err := pay_fee_from(&child, 0, want, sign); err != nil {
+		return Tx{}, err
+	}
+	return child, nil
+}
+
+// PackageFees reports the fees and feerates (sat/vB) of a parent and a child
+// spending it, on their own and together. Miners consider the package rate
+// when the child pays more than the parent.
+type PackageFees struct {
+	ParentFee, ChildFee     int64
+	ParentVSize, ChildVSize int
+	ParentRate, ChildRate   float64
+	PackageRate             float64
+}
+
+func PackageFeeRate(parent, child Tx) (PackageFees, error) {
+	parent_fee, err := parent.Fee()
+	if err != nil {
+		return PackageFees{}, err
+	}
+	child_fee, err := child.Fee()
+	if err != nil {
+		return PackageFees{}, err
+	}
+	p := PackageFees{
+		ParentFee:   parent_fee,
+		ChildFee:    child_fee,
+		ParentVSize: parent.VSize(),
+		ChildVSize:  child.VSize(),
+	}
+	p.ParentRate = float64(p.ParentFee) / float64(p.ParentVSize)
+	p.ChildRate = float64(p.ChildFee) / float64(p.ChildVSize)
+	p.PackageRate = float64(p.ParentFee+p.ChildFee) / float64(p.ParentVSize+p.ChildVSize)
+	return p, nil
+}
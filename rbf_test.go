@@ -0,0 +1,107 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+// p2wpkh_signer returns a sign function for transactions whose inputs all
+// spend P2WPKH outputs of key.
+func p2wpkh_signer(gen Generator, key *big.Int) (func(tx *Tx) error, []byte) {
+	pubkey := PublicKey{gen.base_multiply(key)}.encode(true, false)
+	return func(tx *Tx) error {
+		for i := range tx.tx_ins {
+			msg, err := tx.SigMessage(i)
+			if err != nil {
+				return err
+			}
+			tx.tx_ins[i].witness = [][]byte{append(test_sign(key, gen, msg).sig_encode(), SIGHASH_ALL), pubkey}
+		}
+		return nil
+	}, pubkey
+}
+
+func TestReplaceByFee(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	signer, pubkey := p2wpkh_signer(gen, big.NewInt(4242))
+	var h Hash
+	h[1] = 1
+	in := NewTxIn(h, 0)
+	in.prev_tx_script_pubkey = P2WPKH(Hash160(pubkey))
+	in.prev_amount = 100000
+	tx := Tx{version: 2, tx_ins: []TxIn{in}, tx_outs: []TxOut{{30000, P2PKH(make([]byte, 20))}, {69800, P2WPKH(Hash160(pubkey))}}}
+	if _, err := ReplaceByFee(tx, 1, 5, signer); err == nil {
+		t.Fatal("replaced a transaction that does not signal RBF")
+	}
+	tx.SignalRBF()
+	if !tx.SignalsRBF() {
+		t.Fatal("SignalRBF")
+	}
+	signer(&tx)
+	replacement, err := ReplaceByFee(tx, 1, 10, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate, _ := replacement.FeeRate(); rate < 10 {
+		t.Errorf("replacement pays %v sat/vB", rate)
+	}
+	if err := replacement.VerifyInput(0, gen); err != nil {
+		t.Fatal(err)
+	}
+	if replacement.tx_outs[0].amount != 30000 {
+		t.Error("the fee was taken from the payment")
+	}
+	// Even a bump to a lower rate pays the old fee and the incremental
+	// relay fee.
+	again, err := ReplaceByFee(replacement, 1, 1, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old_fee, _ := replacement.Fee()
+	new_fee, _ := again.Fee()
+	if new_fee < old_fee+int64(incremental_relay_fee*again.VSize()) {
+		t.Errorf("bump from %v to %v does not pay the incremental relay fee", old_fee, new_fee)
+	}
+	if CheckReplacement(tx, []Tx{replacement}) == nil {
+		t.Error("a lower fee replacement passes")
+	}
+	if _, err := ReplaceByFee(tx, 1, 1000, signer); err == nil {
+		t.Error("fee larger than the change")
+	}
+}
+
+func TestCPFP(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	signer, pubkey := p2wpkh_signer(gen, big.NewInt(4242))
+	var h Hash
+	h[1] = 1
+	in := NewTxIn(h, 0)
+	in.prev_tx_script_pubkey = P2WPKH(Hash160(pubkey))
+	in.prev_amount = 100000
+	parent := Tx{version: 2, tx_ins: []TxIn{in}, tx_outs: []TxOut{{30000, P2PKH(make([]byte, 20))}, {69800, P2WPKH(Hash160(pubkey))}}}
+	signer(&parent)
+	child, err := CPFP(parent, 1, P2WPKH(Hash160(pubkey)), 25, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := child.VerifyInput(0, gen); err != nil {
+		t.Fatal(err)
+	}
+	p, _ := PackageFeeRate(parent, child)
+	if p.PackageRate < 25 || p.ChildRate <= p.ParentRate {
+		t.Errorf("package %+v", p)
+	}
+	// The parent already pays 1 sat/vB, but the child must still pay for
+	// itself to be relayed.
+	child, err = CPFP(parent, 1, P2WPKH(Hash160(pubkey)), 1, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fee, _ := child.Fee()
+	if fee < fee_for(child.VSize(), min_relay_fee) {
+		t.Errorf("child pays %v for %v vbytes", fee, child.VSize())
+	}
+	if _, err := CPFP(parent, 2, P2WPKH(Hash160(pubkey)), 25, signer); err == nil {
+		t.Error("spent an output the parent does not have")
+	}
+}
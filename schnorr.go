@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+)
+
+// BIP340 Schnorr signatures. Public keys are the 32 byte x coordinate of a
+// point with an even y coordinate, signatures are the 64 bytes R.x || s.
+
+// lift_x returns the point with x coordinate x and an even y coordinate.
+func lift_x(curve Curve, x []byte) (Point, bool) {
+	xi := new(big.Int).SetBytes(x)
+	if len(x) != 32 || xi.Cmp(curve.p) != -1 {
+		return curve.Infinity(), false
+	}
+	fx := NewFieldElement(curve.fp, xi)
+	y, ok := curve.rhs(fx).Sqrt()
+	if !ok {
+		return curve.Infinity(), false
+	}
+	if y.IsOdd() {
+		y = y.Neg()
+	}
+	return Point{curve: curve, x: fx, y: y}, true
+}
+
+// schnorr_sign signs message with a secret key in [1, n-1]. aux is the
+// auxiliary random data BIP340 mixes into the nonce.
+func schnorr_sign(secret_key *big.Int, gen Generator, message []byte, aux []byte) ([]byte, error) {
+	if secret_key.Sign() <= 0 || secret_key.Cmp(gen.n) >= 0 {
+		return nil, fmt.Errorf("secret key is not in [1, n-1]")
+	}
+	d := NewScalar(gen.fn, secret_key)
+	P := gen.base_multiply(d.Big())
+	if P.y.IsOdd() {
+		d = d.Neg()
+	}
+	t := d.Bytes()
+	aux_hash := tagged_hash("BIP0340/aux", aux)
+	for i := range t {
+		t[i] ^= aux_hash[i]
+	}
+	px := P.x.Bytes()
+	rand := tagged_hash("BIP0340/nonce", bytes.Join([][]byte{t, px, message}, []byte("")))
+	k := NewScalar(gen.fn, new(big.Int).SetBytes(rand))
+	if k.IsZero() {
+		return nil, fmt.Errorf("schnorr nonce is zero")
+	}
+	R := gen.base_multiply(k.Big())
+	if R.y.IsOdd() {
+		k = k.Neg()
+	}
+	rx := R.x.Bytes()
+	e := schnorr_challenge(gen, rx, px, message)
+	s := k.Add(e.Mul(d))
+	return append(rx, s.Bytes()...), nil
+}
+
+func schnorr_challenge(gen Generator, rx, px, message []byte) Scalar {
+	h := tagged_hash("BIP0340/challenge", bytes.Join([][]byte{rx, px, message}, []byte("")))
+	return NewScalar(gen.fn, new(big.Int).SetBytes(h))
+}
+
+// schnorr_parse splits a signature into r and s, rejecting r >= p and s >= n.
+func schnorr_parse(gen Generator, sig []byte) (rx []byte, s Scalar, ok bool) {
+	if len(sig) != 64 {
+		return nil, Scalar{}, false
+	}
+	if new(big.Int).SetBytes(sig[:32]).Cmp(gen.G.curve.p) != -1 {
+		return nil, Scalar{}, false
+	}
+	si := new(big.Int).SetBytes(sig[32:])
+	if si.Cmp(gen.n) != -1 {
+		return nil, Scalar{}, false
+	}
+	return sig[:32], NewScalar(gen.fn, si), true
+}
+
+func schnorr_verify(public_key []byte, gen Generator, message []byte, sig []byte) bool {
+	P, ok := lift_x(gen.G.curve, public_key)
+	if !ok {
+		return false
+	}
+	rx, s, ok := schnorr_parse(gen, sig)
+	if !ok {
+		return false
+	}
+	e := schnorr_challenge(gen, rx, public_key, message)
+	R, err := multi_scalar_multiply([]Point{*gen.G, P}, []*big.Int{s.Big(), e.Neg().Big()})
+	if err != nil || R.inf || R.y.IsOdd() {
+		return false
+	}
+	return bytes.Equal(R.x.Bytes(), rx)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Signing vectors 0 and 1 from BIP340.
+func TestSchnorrVectors(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	vectors := []struct{ secret, public, aux, message, sig string }{
+		{
+			"0000000000000000000000000000000000000000000000000000000000000003",
+			"F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA821525F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0",
+		},
+		{
+			"B7E151628AED2A6ABF7158809CF4F3C762E7160F38B4DA56A784D9045190CFEF",
+			"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+			"0000000000000000000000000000000000000000000000000000000000000001",
+			"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+			"6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE33418906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A",
+		},
+	}
+	for i, v := range vectors {
+		secret := new(big.Int).SetBytes(unhex(t, v.secret))
+		public, message, want := unhex(t, v.public), unhex(t, v.message), unhex(t, v.sig)
+		if got := gen.base_multiply(secret).x.Bytes(); !bytes.Equal(got, public) {
+			t.Errorf("vector %v: public key %x", i, got)
+		}
+		sig, err := schnorr_sign(secret, gen, message, unhex(t, v.aux))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(sig, want) {
+			t.Errorf("vector %v: signature %x", i, sig)
+		}
+		if !schnorr_verify(public, gen, message, want) {
+			t.Errorf("vector %v: signature rejected", i)
+		}
+		want[63] ^= 1
+		if schnorr_verify(public, gen, message, want) {
+			t.Errorf("vector %v: modified signature accepted", i)
+		}
+	}
+}
+
+func TestSchnorrSecretKeyRange(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	for _, k := range []*big.Int{big.NewInt(0), big.NewInt(-1), gen.n, new(big.Int).Add(gen.n, big.NewInt(1))} {
+		if _, err := schnorr_sign(k, gen, make([]byte, 32), make([]byte, 32)); err == nil {
+			t.Errorf("signed with secret key %v", k)
+		}
+	}
+	if _, err := schnorr_sign(new(big.Int).Sub(gen.n, big.NewInt(1)), gen, make([]byte, 32), make([]byte, 32)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Opcodes used by the standard script templates.
+const (
+	OP_0             = 0x00
+	OP_PUSHDATA1     = 0x4c
+	OP_PUSHDATA2     = 0x4d
+	OP_PUSHDATA4     = 0x4e
+	OP_1NEGATE       = 0x4f
+	OP_1             = 0x51
+	OP_16            = 0x60
+	OP_RETURN        = 0x6a
+	OP_DUP           = 0x76
+	OP_EQUAL         = 0x87
+	OP_EQUALVERIFY   = 0x88
+	OP_HASH160       = 0xa9
+	OP_CHECKSIG      = 0xac
+	OP_CHECKMULTISIG = 0xae
+)
+
+// push_data returns the shortest script fragment that pushes data onto the stack.
+func push_data(data []byte) []byte {
+	n := len(data)
+	var out []byte
+	switch {
+	case n < OP_PUSHDATA1:
+		out = []byte{byte(n)}
+	case n <= 0xff:
+		out = []byte{OP_PUSHDATA1, byte(n)}
+	case n <= 0xffff:
+		out = []byte{OP_PUSHDATA2, 0, 0}
+		binary.LittleEndian.PutUint16(out[1:], uint16(n))
+	default:
+		out = []byte{OP_PUSHDATA4, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(out[1:], uint32(n))
+	}
+	return append(out, data...)
+}
+
+// small_int_op returns the opcode that pushes n, for 0 <= n <= 16.
+func small_int_op(n int) byte {
+	if n == 0 {
+		return OP_0
+	}
+	return byte(OP_1 + n - 1)
+}
+
+// push_num returns the shortest script fragment that pushes the script number n.
+func push_num(n int64) []byte {
+	switch {
+	case n == -1:
+		return []byte{OP_1NEGATE}
+	case n >= 0 && n <= 16:
+		return []byte{small_int_op(int(n))}
+	}
+	return push_data(encode_num(n))
+}
+
+// ScriptOp is a single parsed instruction: an opcode and, for pushes, the data pushed.
+type ScriptOp struct {
+	opcode byte
+	data   []byte
+}
+
+func (op ScriptOp) is_push() bool {
+	return op.opcode <= OP_PUSHDATA4
+}
+
+// small_int returns the number an OP_0, OP_1..OP_16 instruction pushes.
+func (op ScriptOp) small_int() (int, bool) {
+	if op.opcode == OP_0 {
+		return 0, true
+	}
+	if op.opcode >= OP_1 && op.opcode <= OP_16 {
+		return int(op.opcode-OP_1) + 1, true
+	}
+	return 0, false
+}
+
+// parse_script splits raw script bytes into instructions.
+func parse_script(b []byte) ([]ScriptOp, error) {
+	var ops []ScriptOp
+	for i := 0; i < len(b); {
+		op := b[i]
+		i++
+		var n int
+		switch {
+		case op < OP_PUSHDATA1:
+			n = int(op)
+		case op == OP_PUSHDATA1:
+			if i+1 > len(b) {
+				return nil, fmt.Errorf("script: truncated OP_PUSHDATA1")
+			}
+			n = int(b[i])
+			i++
+		case op == OP_PUSHDATA2:
+			if i+2 > len(b) {
+				return nil, fmt.Errorf("script: truncated OP_PUSHDATA2")
+			}
+			n = int(binary.LittleEndian.Uint16(b[i:]))
+			i += 2
+		case op == OP_PUSHDATA4:
+			if i+4 > len(b) {
+				return nil, fmt.Errorf("script: truncated OP_PUSHDATA4")
+			}
+			n = int(binary.LittleEndian.Uint32(b[i:]))
+			i += 4
+		default:
+			ops = append(ops, ScriptOp{opcode: op})
+			continue
+		}
+		if n < 0 || i+n > len(b) {
+			return nil, fmt.Errorf("script: push of %v bytes runs past the end", n)
+		}
+		ops = append(ops, ScriptOp{opcode: op, data: b[i : i+n]})
+		i += n
+	}
+	return ops, nil
+}
+
+// script_body returns the raw bytes of a script, without the length prefix
+// ScriptEncode puts in front of them.
+func script_body(s Script) []byte {
+	if bs, ok := s.(ByteScript); ok {
+		return bs.cmds
+	}
+	enc := s.ScriptEncode()
+	_, n, err := decode_varint(enc)
+	if err != nil {
+		return nil
+	}
+	return enc[n:]
+}
+
+func P2PK(pubkey []byte) ByteScript {
+	return ByteScript{cmds: append(push_data(pubkey), OP_CHECKSIG)}
+}
+
+func P2PKH(pubkey_hash []byte) ByteScript {
+	cmds := []byte{OP_DUP, OP_HASH160}
+	cmds = append(cmds, push_data(pubkey_hash)...)
+	cmds = append(cmds, OP_EQUALVERIFY, OP_CHECKSIG)
+	return ByteScript{cmds: cmds}
+}
+
+func P2SH(script_hash []byte) ByteScript {
+	cmds := []byte{OP_HASH160}
+	cmds = append(cmds, push_data(script_hash)...)
+	cmds = append(cmds, OP_EQUAL)
+	return ByteScript{cmds: cmds}
+}
+
+// witness_program returns the scriptPubKey of a segwit output: the version
+// followed by a single push of the program.
+func witness_program(version int, program []byte) ByteScript {
+	return ByteScript{cmds: append([]byte{small_int_op(version)}, push_data(program)...)}
+}
+
+func P2WPKH(pubkey_hash []byte) ByteScript {
+	return witness_program(0, pubkey_hash)
+}
+
+func P2WSH(script_hash []byte) ByteScript {
+	return witness_program(0, script_hash)
+}
+
+// P2TR takes the 32 byte x-only taproot output key.
+func P2TR(output_key []byte) ByteScript {
+	return witness_program(1, output_key)
+}
+
+// Multisig returns the bare m-of-n script OP_m <pubkey>... OP_n OP_CHECKMULTISIG.
+func Multisig(m int, pubkeys [][]byte) (ByteScript, error) {
+	if len(pubkeys) < 1 || len(pubkeys) > 16 || m < 1 || m > len(pubkeys) {
+		return ByteScript{}, fmt.Errorf("invalid %v-of-%v multisig", m, len(pubkeys))
+	}
+	cmds := []byte{small_int_op(m)}
+	for _, pk := range pubkeys {
+		cmds = append(cmds, push_data(pk)...)
+	}
+	cmds = append(cmds, small_int_op(len(pubkeys)), OP_CHECKMULTISIG)
+	return ByteScript{cmds: cmds}, nil
+}
+
+// OpReturn returns an unspendable output script carrying data.
+func OpReturn(data []byte) ByteScript {
+	return ByteScript{cmds: append([]byte{OP_RETURN}, push_data(data)...)}
+}
+
+type ScriptClass int
+
+const (
+	NonStandard ScriptClass = iota
+	PubKeyClass
+	PubKeyHashClass
+	ScriptHashClass
+	WitnessPubKeyHashClass
+	WitnessScriptHashClass
+	TaprootClass
+	MultisigClass
+	NullDataClass
+)
+
+var script_class_names = [...]string{"nonstandard", "pubkey", "pubkeyhash", "scripthash", "witness_v0_keyhash", "witness_v0_scripthash", "witness_v1_taproot", "multisig", "nulldata"}
+
+func (c ScriptClass) String() string {
+	if c < 0 || int(c) >= len(script_class_names) {
+		return fmt.Sprintf("ScriptClass(%d)", int(c))
+	}
+	return script_class_names[c]
+}
+
+// ScriptTemplate is what Classify found out about a script. Hash holds the
+// key hash, script hash or taproot output key, PubKeys the keys of P2PK and
+// multisig scripts, Required the m of an m-of-n multisig, and Data the
+// payload of an OP_RETURN output.
+type ScriptTemplate struct {
+	Class    ScriptClass
+	Hash     []byte
+	PubKeys  [][]byte
+	Required int
+	Data     []byte
+}
+
+func is_pubkey(b []byte) bool {
+	return (len(b) == 33 && (b[0] == 0x02 || b[0] == 0x03)) || (len(b) == 65 && b[0] == 0x04)
+}
+
+// Classify recognises the standard output script templates.
+func Classify(s Script) ScriptTemplate {
+	raw := script_body(s)
+	ops, err := parse_script(raw)
+	if err != nil || len(ops) == 0 {
+		return ScriptTemplate{Class: NonStandard}
+	}
+	// Templates must use minimal pushes, so compare against a rebuilt script.
+	same := func(b ByteScript) bool {
+		return bytes.Equal(raw, b.cmds)
+	}
+	switch {
+	case len(ops) == 2 && ops[1].opcode == OP_CHECKSIG && is_pubkey(ops[0].data) && same(P2PK(ops[0].data)):
+		return ScriptTemplate{Class: PubKeyClass, PubKeys: [][]byte{ops[0].data}}
+	case len(ops) == 5 && len(ops[2].data) == 20 && same(P2PKH(ops[2].data)):
+		return ScriptTemplate{Class: PubKeyHashClass, Hash: ops[2].data}
+	case len(ops) == 3 && len(ops[1].data) == 20 && same(P2SH(ops[1].data)):
+		return ScriptTemplate{Class: ScriptHashClass, Hash: ops[1].data}
+	case len(ops) == 2 && ops[0].opcode == OP_0 && len(ops[1].data) == 20 && same(P2WPKH(ops[1].data)):
+		return ScriptTemplate{Class: WitnessPubKeyHashClass, Hash: ops[1].data}
+	case len(ops) == 2 && ops[0].opcode == OP_0 && len(ops[1].data) == 32 && same(P2WSH(ops[1].data)):
+		return ScriptTemplate{Class: WitnessScriptHashClass, Hash: ops[1].data}
+	case len(ops) == 2 && ops[0].opcode == OP_1 && len(ops[1].data) == 32 && same(P2TR(ops[1].data)):
+		return ScriptTemplate{Class: TaprootClass, Hash: ops[1].data}
+	case ops[0].opcode == OP_RETURN && len(ops) <= 2:
+		var data []byte
+		if len(ops) == 2 {
+			if !ops[1].is_push() {
+				break
+			}
+			data = ops[1].data
+		}
+		return ScriptTemplate{Class: NullDataClass, Data: data}
+	case len(ops) >= 4 && ops[len(ops)-1].opcode == OP_CHECKMULTISIG:
+		m, ok_m := ops[0].small_int()
+		n, ok_n := ops[len(ops)-2].small_int()
+		if !ok_m || !ok_n || n != len(ops)-3 {
+			break
+		}
+		var pubkeys [][]byte
+		for _, op := range ops[1 : len(ops)-2] {
+			if !is_pubkey(op.data) {
+				return ScriptTemplate{Class: NonStandard}
+			}
+			pubkeys = append(pubkeys, op.data)
+		}
+		if ms, err := Multisig(m, pubkeys); err != nil || !same(ms) {
+			break
+		}
+		return ScriptTemplate{Class: MultisigClass, PubKeys: pubkeys, Required: m}
+	}
+	return ScriptTemplate{Class: NonStandard}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestPushData(t *testing.T) {
+	for _, n := range []int{0, 1, 75, 76, 255, 256, 65535, 65536} {
+		data := bytes.Repeat([]byte{0xab}, n)
+		script := push_data(data)
+		ops, err := parse_script(script)
+		if err != nil || len(ops) != 1 || !bytes.Equal(ops[0].data, data) {
+			t.Fatalf("push of %v bytes does not parse back: %v", n, err)
+		}
+		prefix := len(script) - n
+		want := 1
+		switch {
+		case n > 65535:
+			want = 5
+		case n > 255:
+			want = 3
+		case n > 75:
+			want = 2
+		}
+		if prefix != want {
+			t.Errorf("push of %v bytes has a %v byte prefix, want %v", n, prefix, want)
+		}
+	}
+	for n, want := range map[int64]string{-1: "4f", 0: "00", 1: "51", 16: "60", 17: "0111", -2: "0182", 128: "028000"} {
+		if got := hex.EncodeToString(push_num(n)); got != want {
+			t.Errorf("push_num(%v) = %v, want %v", n, got, want)
+		}
+	}
+}
+
+func TestParseScriptTruncated(t *testing.T) {
+	for _, s := range []string{"01", "4c", "4c02ab", "4d01", "4d0100", "4e010000", "4e01000000"} {
+		b, _ := hex.DecodeString(s)
+		if _, err := parse_script(b); err == nil {
+			t.Errorf("parsed truncated script %v", s)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	pub := PublicKey{*gen.G}
+	compressed := pub.encode(true, false)
+	hash20 := pub.encode(true, true)
+	hash32 := sha256(compressed)
+	multisig, _ := Multisig(1, [][]byte{compressed, pub.encode(false, false)})
+	cases := []struct {
+		script Script
+		class  ScriptClass
+		hash   []byte
+	}{
+		{P2PK(compressed), PubKeyClass, nil},
+		{P2PKH(hash20), PubKeyHashClass, hash20},
+		{P2SH(hash20), ScriptHashClass, hash20},
+		{P2WPKH(hash20), WitnessPubKeyHashClass, hash20},
+		{P2WSH(hash32), WitnessScriptHashClass, hash32},
+		{P2TR(hash32), TaprootClass, hash32},
+		{multisig, MultisigClass, nil},
+		{OpReturn([]byte("hello")), NullDataClass, nil},
+		{ByteScript{}, NonStandard, nil},
+		{ByteScript{cmds: []byte{OP_DUP}}, NonStandard, nil},
+		// A P2PKH script with a non-minimal push of the hash.
+		{ByteScript{cmds: append(append([]byte{OP_DUP, OP_HASH160, OP_PUSHDATA1, 20}, hash20...), OP_EQUALVERIFY, OP_CHECKSIG)}, NonStandard, nil},
+		// A witness program of the wrong size.
+		{witness_program(0, hash20[:19]), NonStandard, nil},
+	}
+	for i, c := range cases {
+		got := Classify(c.script)
+		if got.Class != c.class || !bytes.Equal(got.Hash, c.hash) {
+			t.Errorf("case %v: classified as %v %x, want %v %x", i, got.Class, got.Hash, c.class, c.hash)
+		}
+	}
+	if got := Classify(multisig); got.Required != 1 || len(got.PubKeys) != 2 {
+		t.Errorf("multisig template %+v", got)
+	}
+	if got := Classify(OpReturn([]byte("hello"))); string(got.Data) != "hello" {
+		t.Errorf("OP_RETURN data %q", got.Data)
+	}
+	want := "76a914751e76e8199196d454941c45d1b3a323f1433bd688ac"
+	if got := hex.EncodeToString(P2PKH(hash20).cmds); got != want {
+		t.Errorf("P2PKH = %v", got)
+	}
+}
+
+func TestScriptClassString(t *testing.T) {
+	if MultisigClass.String() != "multisig" || NonStandard.String() != "nonstandard" {
+		t.Error("class names")
+	}
+	if got := ScriptClass(42).String(); got != "ScriptClass(42)" {
+		t.Errorf("ScriptClass(42).String() = %v", got)
+	}
+	if got := ScriptClass(-1).String(); got != "ScriptClass(-1)" {
+		t.Errorf("ScriptClass(-1).String() = %v", got)
+	}
+}
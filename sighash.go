@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// SIGHASH_ALL is the only signature hash type supported: the signature
+// commits to every input and every output.
+const SIGHASH_ALL = 0x01
+
+// script_code returns the script the signatures of input index commit to,
+// and whether they are segwit (BIP143) signatures. For P2SH outputs that is
+// the redeem script, for P2WPKH the equivalent P2PKH script and for P2WSH the
+// witness script, whether or not the witness program is wrapped in P2SH.
+func (t Tx) script_code(index int) ([]byte, bool, error) {
+	if index < 0 || index >= len(t.tx_ins) {
+		return nil, false, fmt.Errorf("no input %v", index)
+	}
+	in := t.tx_ins[index]
+	if in.prev_tx_script_pubkey == nil {
+		return nil, false, fmt.Errorf("input %v: scriptPubKey of the spent output is not known", index)
+	}
+	code := script_body(in.prev_tx_script_pubkey)
+	tmpl := Classify(ByteScript{cmds: code})
+	if tmpl.Class == ScriptHashClass {
+		if in.redeem_script == nil {
+			return nil, false, fmt.Errorf("input %v: spending a P2SH output needs the redeem script", index)
+		}
+		code = script_body(in.redeem_script)
+		if !bytes.Equal(Hash160(code), tmpl.Hash) {
+			return nil, false, fmt.Errorf("input %v: redeem script does not match the script hash", index)
+		}
+		tmpl = Classify(ByteScript{cmds: code})
+	}
+	switch tmpl.Class {
+	case WitnessPubKeyHashClass:
+		return P2PKH(tmpl.Hash).cmds, true, nil
+	case WitnessScriptHashClass:
+		if in.witness_script == nil {
+			return nil, false, fmt.Errorf("input %v: spending a P2WSH output needs the witness script", index)
+		}
+		code = script_body(in.witness_script)
+		if !bytes.Equal(sha256(code), tmpl.Hash) {
+			return nil, false, fmt.Errorf("input %v: witness script does not match the script hash", index)
+		}
+		return code, true, nil
+	case TaprootClass:
+		return nil, false, fmt.Errorf("input %v: taproot spending is not supported", index)
+	}
+	return code, false, nil
+}
+
+// SigMessage returns the message that sign and verify take for input index,
+// i.e. the serialization whose double sha256 is the signature hash.
+func (t Tx) SigMessage(index int) ([]byte, error) {
+	code, segwit, err := t.script_code(index)
+	if err != nil {
+		return nil, err
+	}
+	if segwit {
+		return t.bip143_message(index, code), nil
+	}
+	return t.legacy_message(index, code), nil
+}
+
+// legacy_message is TxEncode(index) with the scriptPubKey of the spent output
+// replaced by code.
+func (t Tx) legacy_message(index int, code []byte) []byte {
+	tx_ins := make([]TxIn, len(t.tx_ins))
+	copy(tx_ins, t.tx_ins)
+	tx_ins[index].prev_tx_script_pubkey = ByteScript{cmds: code}
+	t.tx_ins = tx_ins
+	return t.TxEncode(index)
+}
+
+// bip143_message is the BIP143 serialization signed by segwit v0 inputs. It
+// commits to the amount being spent, so signing never needs the previous
+// transactions.
+func (t Tx) bip143_message(index int, code []byte) []byte {
+	u32 := func(n uint32) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, n)
+		return b
+	}
+	var prevouts, sequences, outputs []byte
+	for _, tx_in := range t.tx_ins {
+		prevouts = append(prevouts, tx_in.prev_tx[:]...)
+		prevouts = append(prevouts, u32(uint32(tx_in.prev_index))...)
+		sequences = append(sequences, u32(tx_in.sequence)...)
+	}
+	for _, tx_out := range t.tx_outs {
+		outputs = append(outputs, tx_out.txout_encode()...)
+	}
+	in := t.tx_ins[index]
+	hash_prevouts := Hash256(prevouts)
+	hash_sequence := Hash256(sequences)
+	hash_outputs := Hash256(outputs)
+	amount := make([]byte, 8)
+	binary.LittleEndian.PutUint64(amount, uint64(in.prev_amount))
+	return bytes.Join([][]byte{
+		u32(t.version),
+		hash_prevouts[:],
+		hash_sequence[:],
+		in.prev_tx[:],
+		u32(uint32(in.prev_index)),
+		ByteScript{cmds: code}.ScriptEncode(),
+		amount,
+		u32(in.sequence),
+		hash_outputs[:],
+		u32(t.locktime),
+		u32(SIGHASH_ALL),
+	}, []byte(""))
+}
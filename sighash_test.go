@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func unhex_hash(t *testing.T, s string) Hash {
+	t.Helper()
+	var h Hash
+	copy(h[:], unhex(t, s))
+	return h
+}
+
+// The native P2WPKH example of BIP143.
+func TestBIP143(t *testing.T) {
+	in0 := NewTxIn(unhex_hash(t, "fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f"), 0)
+	in0.sequence = 0xffffffee
+	in0.prev_tx_script_pubkey = ByteScript{cmds: unhex(t, "2103c9f4836b9a4f77fc0d81f7bcb01b7f1b35916864b9476c241ce9fc198bd25432ac")}
+	in1 := NewTxIn(unhex_hash(t, "ef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a"), 1)
+	in1.prev_tx_script_pubkey = ByteScript{cmds: unhex(t, "00141d0f172a0ecb48aee1be1f2687d2963ae33f71a1")}
+	in1.prev_amount = 600000000
+	tx := Tx{
+		version: 1,
+		tx_ins:  []TxIn{in0, in1},
+		tx_outs: []TxOut{
+			{112340000, ByteScript{cmds: unhex(t, "76a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac")}},
+			{223450000, ByteScript{cmds: unhex(t, "76a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac")}},
+		},
+		locktime: 17,
+	}
+	m, err := tx.SigMessage(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Hash256(m)
+	if got := hex.EncodeToString(h[:]); got != "c37af31116d1b27caf68aae9e3ac82f1477929014d5b917657d0eb49478cb670" {
+		t.Fatalf("sighash %v", got)
+	}
+	// The legacy input signs the transaction with its script in place.
+	m, err = tx.SigMessage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m, tx.TxEncode(0)) {
+		t.Fatal("legacy message is not the transaction with the input's script")
+	}
+}
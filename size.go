@@ -0,0 +1,202 @@
+package main
+
+import "fmt"
+
+// witness_scale_factor is how much more a non-witness byte weighs than a
+// witness byte (BIP141).
+const witness_scale_factor = 4
+
+// Size is the length in bytes of the serialized transaction, witnesses included.
+func (t Tx) Size() int {
+	return len(t.TxEncode(-1))
+}
+
+// Weight counts each byte of the transaction without its witnesses four
+// times and each witness byte (marker and flag included) once.
+func (t Tx) Weight() int {
+	base := len(t.encode(-1, false))
+	return (witness_scale_factor-1)*base + t.Size()
+}
+
+// VSize is the weight in virtual bytes, rounded up. Feerates are per vbyte.
+func (t Tx) VSize() int {
+	return (t.Weight() + witness_scale_factor - 1) / witness_scale_factor
+}
+
+// SpendType is the kind of output an input spends, which decides what its
+// scriptSig and witness will hold once signed.
+type SpendType int
+
+const (
+	SpendP2PKH SpendType = iota
+	SpendP2WPKH
+	SpendP2SHP2WPKH
+	SpendP2TR // key path
+	SpendMultisigP2SH
+	SpendMultisigP2WSH
+	SpendMultisigP2SHP2WSH
+)
+
+// Sizes assumed for things that do not exist before signing: the longest
+// DER signature with its sighash byte, a BIP340 signature with the default
+// sighash (no sighash byte), and a compressed public key.
+const (
+	max_ecdsa_sig_size = 72
+	schnorr_sig_size   = 64
+	pubkey_size        = 33
+)
+
+// InputSpend describes an input before it is signed. M and N are the m and
+// n of an m-of-n multisig and ignored for the other types.
+type InputSpend struct {
+	Type SpendType
+	M, N int
+}
+
+// push_size is the size of push_data(make([]byte, n)).
+func push_size(n int) int {
+	return len(push_data(make([]byte, n)))
+}
+
+// witness_size is the size of an encoded witness with items of these lengths.
+func witness_size(items ...int) int {
+	size := len(encode_varint(uint64(len(items))))
+	for _, n := range items {
+		size += len(encode_varint(uint64(n))) + n
+	}
+	return size
+}
+
+// parts returns the size of the input's scriptSig and of its witness, 0 if it
+// has none.
+func (s InputSpend) parts() (script_sig int, witness int, err error) {
+	multisig := func() (script_size int, witness_items []int, err error) {
+		if s.N < 1 || s.N > 16 || s.M < 1 || s.M > s.N {
+			return 0, nil, fmt.Errorf("invalid %v-of-%v multisig", s.M, s.N)
+		}
+		// OP_m <pubkey>... OP_n OP_CHECKMULTISIG
+		script_size = 3 + s.N*push_size(pubkey_size)
+		// The dummy element, then m signatures.
+		witness_items = []int{0}
+		for i := 0; i < s.M; i++ {
+			witness_items = append(witness_items, max_ecdsa_sig_size)
+		}
+		return script_size, witness_items, nil
+	}
+	switch s.Type {
+	case SpendP2PKH:
+		return push_size(max_ecdsa_sig_size) + push_size(pubkey_size), 0, nil
+	case SpendP2WPKH:
+		return 0, witness_size(max_ecdsa_sig_size, pubkey_size), nil
+	case SpendP2SHP2WPKH:
+		return push_size(22), witness_size(max_ecdsa_sig_size, pubkey_size), nil
+	case SpendP2TR:
+		return 0, witness_size(schnorr_sig_size), nil
+	case SpendMultisigP2SH:
+		script_size, items, err := multisig()
+		if err != nil {
+			return 0, 0, err
+		}
+		script_sig = push_size(script_size)
+		for _, n := range items {
+			script_sig += push_size(n)
+		}
+		return script_sig, 0, nil
+	case SpendMultisigP2WSH, SpendMultisigP2SHP2WSH:
+		script_size, items, err := multisig()
+		if err != nil {
+			return 0, 0, err
+		}
+		witness = witness_size(append(items, script_size)...)
+		if s.Type == SpendMultisigP2SHP2WSH {
+			script_sig = push_size(34)
+		}
+		return script_sig, witness, nil
+	}
+	return 0, 0, fmt.Errorf("unknown spend type %v", s.Type)
+}
+
+// Weight is the weight of the signed input, witness included.
+func (s InputSpend) Weight() (int, error) {
+	script_sig, witness, err := s.parts()
+	if err != nil {
+		return 0, err
+	}
+	// outpoint, scriptSig and sequence
+	base := 36 + len(encode_varint(uint64(script_sig))) + script_sig + 4
+	return witness_scale_factor*base + witness, nil
+}
+
+// VSize is the input's weight in vbytes, rounded up.
+func (s InputSpend) VSize() (int, error) {
+	w, err := s.Weight()
+	return (w + witness_scale_factor - 1) / witness_scale_factor, err
+}
+
+// SpendFor guesses how an output is spent from its scriptPubKey. P2SH
+// outputs could hide anything and are taken to be P2SH-P2WPKH; multisig
+// spends need an InputSpend built by hand.
+func SpendFor(script_pubkey Script) (InputSpend, error) {
+	switch c := Classify(script_pubkey).Class; c {
+	case PubKeyHashClass:
+		return InputSpend{Type: SpendP2PKH}, nil
+	case WitnessPubKeyHashClass:
+		return InputSpend{Type: SpendP2WPKH}, nil
+	case ScriptHashClass:
+		return InputSpend{Type: SpendP2SHP2WPKH}, nil
+	case TaprootClass:
+		return InputSpend{Type: SpendP2TR}, nil
+	default:
+		return InputSpend{}, fmt.Errorf("cannot tell how a %v output is spent", c)
+	}
+}
+
+// EstimateWeight returns the weight tx will have once its inputs are signed
+// as spends describes, one entry per input. Whatever the inputs hold now is
+// ignored.
+func (t Tx) EstimateWeight(spends []InputSpend) (int, error) {
+	if len(spends) != len(t.tx_ins) {
+		return 0, fmt.Errorf("%v inputs but %v spends", len(t.tx_ins), len(spends))
+	}
+	base := 4 + len(encode_varint(uint64(len(t.tx_ins)))) + len(encode_varint(uint64(len(t.tx_outs)))) + 4
+	for _, tx_out := range t.tx_outs {
+		base += len(tx_out.txout_encode())
+	}
+	weight := witness_scale_factor * base
+	segwit := false
+	for _, s := range spends {
+		w, err := s.Weight()
+		if err != nil {
+			return 0, err
+		}
+		weight += w
+		if _, witness, _ := s.parts(); witness > 0 {
+			segwit = true
+		}
+	}
+	if segwit {
+		// The marker and flag, and an empty witness for every legacy input.
+		weight += 2
+		for _, s := range spends {
+			if _, witness, _ := s.parts(); witness == 0 {
+				weight++
+			}
+		}
+	}
+	return weight, nil
+}
+
+// EstimateVSize is EstimateWeight in vbytes, rounded up.
+func (t Tx) EstimateVSize(spends []InputSpend) (int, error) {
+	w, err := t.EstimateWeight(spends)
+	return (w + witness_scale_factor - 1) / witness_scale_factor, err
+}
+
+// EstimateFee is the fee tx needs, once signed, to pay feerate sat/vB.
+func (t Tx) EstimateFee(spends []InputSpend, feerate float64) (int64, error) {
+	vsize, err := t.EstimateVSize(spends)
+	if err != nil {
+		return 0, err
+	}
+	return fee_for(vsize, feerate), nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestInputSpendVSize(t *testing.T) {
+	cases := []struct {
+		spend InputSpend
+		vsize int
+	}{
+		{InputSpend{Type: SpendP2PKH}, 148},
+		{InputSpend{Type: SpendP2WPKH}, 68},
+		{InputSpend{Type: SpendP2SHP2WPKH}, 91},
+		{InputSpend{Type: SpendP2TR}, 58},
+	}
+	for _, c := range cases {
+		if v, err := c.spend.VSize(); err != nil || v != c.vsize {
+			t.Errorf("%+v: vsize %v, %v, want %v", c.spend, v, err, c.vsize)
+		}
+	}
+	if _, err := (InputSpend{Type: SpendType(99)}).VSize(); err == nil {
+		t.Error("unknown spend type")
+	}
+	if _, err := SpendFor(OpReturn(nil)); err == nil {
+		t.Error("SpendFor an OP_RETURN output")
+	}
+}
+
+// check_estimate checks that the estimate for tx is at least its actual
+// weight and over by no more than a byte or so per signature.
+func check_estimate(t *testing.T, name string, tx Tx, spends []InputSpend, sigs int) {
+	t.Helper()
+	estimate, err := tx.EstimateWeight(spends)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := tx.Weight(); estimate < actual || estimate > actual+8*sigs {
+		t.Errorf("%v: estimated weight %v, actual %v", name, estimate, actual)
+	}
+}
+
+func TestEstimateWeight(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	key := big.NewInt(777)
+	pub := PublicKey{gen.base_multiply(key)}
+	pubkey_hash := pub.encode(true, true)
+	b := NewTxBuilder(gen, "test")
+	var h Hash
+	var spends []InputSpend
+	for i, spk := range []ByteScript{P2PKH(pubkey_hash), P2WPKH(pubkey_hash), P2SH(Hash160(P2WPKH(pubkey_hash).cmds))} {
+		h[0] = byte(i)
+		b.AddUTXO(h, i, spk, 20000, key)
+		s, err := SpendFor(spk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spends = append(spends, s)
+	}
+	segwit, _ := SegwitAddress("test", 0, pubkey_hash)
+	b.AddRecipient(segwit, 30000)
+	b.SetChangeAddress(pub.address("test", true))
+	tx, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_estimate(t, "builder", tx, spends, 3)
+	if _, err := tx.EstimateWeight(spends[:2]); err == nil {
+		t.Error("estimate with a spend missing")
+	}
+	if tx.Weight() <= 3*len(tx.encode(-1, false)) || tx.VSize()*4 < tx.Weight() {
+		t.Error("weight and vsize")
+	}
+
+	var keys []*big.Int
+	var pubs []PublicKey
+	for i := 1; i <= 3; i++ {
+		k := big.NewInt(int64(5000 + 7*i))
+		keys = append(keys, k)
+		pubs = append(pubs, PublicKey{gen.base_multiply(k)})
+	}
+	w, _ := NewMultisigWallet(2, pubs)
+	for _, kind := range []MultisigKind{MultisigP2SH, MultisigP2WSH, MultisigP2SHP2WSH} {
+		in := NewTxIn(h, 1)
+		w.PrepareInput(&in, kind, 50000)
+		tx := Tx{version: 2, tx_ins: []TxIn{in}, tx_outs: []TxOut{{40000, P2WPKH(pubkey_hash)}}}
+		s0, _ := w.Sign(tx, 0, keys[0], gen)
+		s1, _ := w.Sign(tx, 0, keys[1], gen)
+		if err := w.Combine(&tx, 0, kind, [][]byte{s0, s1}, gen); err != nil {
+			t.Fatal(err)
+		}
+		check_estimate(t, kind.String(), tx, []InputSpend{{SpendMultisigP2SH + SpendType(kind), 2, 3}}, 2)
+	}
+}
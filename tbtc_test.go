@@ -0,0 +1,149 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestNewPoint(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	curve := gen.G.curve
+	x, y := gen.G.x.Big(), gen.G.y.Big()
+	if _, err := NewPoint(curve, x, y); err != nil {
+		t.Fatal(err)
+	}
+	bad := []struct{ x, y *big.Int }{
+		{x, new(big.Int).Add(y, big.NewInt(1))}, // off the curve
+		{x, new(big.Int).Add(y, curve.p)},       // y + p is y mod p
+		{new(big.Int).Add(x, curve.p), y},       // x + p is x mod p
+		{x, new(big.Int).Neg(y)},                // -y is p - y mod p
+		{big.NewInt(0), big.NewInt(0)},          // not on the curve, not infinity
+		{x, big.NewInt(-1)},
+	}
+	for _, b := range bad {
+		if _, err := NewPoint(curve, b.x, b.y); err == nil {
+			t.Errorf("NewPoint accepted (%x, %x)", b.x, b.y)
+		}
+	}
+}
+
+func TestInfinity(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	inf := gen.G.curve.Infinity()
+	if !inf.IsInfinity() || inf.verify_on_curve(&gen.G.curve) {
+		t.Fatal("infinity")
+	}
+	if !inf.elliptic_curve_addition(*gen.G).Compare(*gen.G) || !gen.G.elliptic_curve_addition(inf).Compare(*gen.G) {
+		t.Error("G + infinity is not G")
+	}
+	neg := Point{curve: gen.G.curve, x: gen.G.x, y: gen.G.y.Neg()}
+	if !gen.G.elliptic_curve_addition(neg).IsInfinity() {
+		t.Error("G + -G is not infinity")
+	}
+	if zero, _ := gen.G.double_and_add(big.NewInt(0)); !zero.IsInfinity() {
+		t.Error("0*G is not infinity")
+	}
+	if _, err := gen.G.double_and_add(big.NewInt(-1)); err == nil {
+		t.Error("-1*G did not fail")
+	}
+	if inf.Compare(*gen.G) || !inf.Compare(gen.G.curve.Infinity()) {
+		t.Error("Compare with infinity")
+	}
+}
+
+func TestDecodePubkey(t *testing.T) {
+	gen, _ := LookupCurve("secp256k1")
+	curve := gen.G.curve
+	for k := int64(1); k < 20; k++ {
+		pub := PublicKey{gen.base_multiply(big.NewInt(k))}
+		for _, compressed := range []bool{true, false} {
+			got, err := decode_pubkey(curve, pub.encode(compressed, false))
+			if err != nil || !got.Compare(pub.Point) {
+				t.Fatalf("%v*G compressed=%v: %v %v", k, compressed, got, err)
+			}
+		}
+	}
+	compressed := PublicKey{*gen.G}.encode(true, false)
+	uncompressed := PublicKey{*gen.G}.encode(false, false)
+	bad := [][]byte{
+		nil,
+		compressed[:32],
+		append([]byte{0x04}, compressed[1:]...),
+		append([]byte{0x05}, uncompressed[1:]...),
+		append([]byte{0x02}, curve.p.Bytes()...),                               // x = p
+		append([]byte{0x02}, make([]byte, 32)...),                              // x = 0: 7 is not a square
+		append(append([]byte{0x04}, uncompressed[1:33]...), compressed[1:]...), // y = x
+	}
+	for _, b := range bad {
+		if _, err := decode_pubkey(curve, b); err == nil {
+			t.Errorf("decoded %x", b)
+		}
+	}
+}
+
+// test_sign is ecdsa_sign for keys known to be valid.
+func test_sign(secret_key *big.Int, gen Generator, message []byte) Signature {
+	sig, err := ecdsa_sign(secret_key, gen, message)
+	if err != nil {
+		panic(err)
+	}
+	return sig
+}
+
+// The nonces bitcoinjs and python-ecdsa test their RFC 6979 implementations
+// with, for single sha256 digests on secp256k1, and the P-256 example of
+// RFC 6979 appendix A.2.5.
+func TestRFC6979(t *testing.T) {
+	k1, _ := LookupCurve("secp256k1")
+	p256, _ := LookupCurve("secp256r1")
+	vectors := []struct {
+		gen                Generator
+		secret, message, k string
+	}{
+		{k1, "1", "Satoshi Nakamoto", "8F8A276C19F4149656B280621E358CCE24F5F52542772691EE69063B74F15D15"},
+		{k1, "1", "All those moments will be lost in time, like tears in rain. Time to die...", "38AA22D72376B4DBC472E06C3BA403EE0A394DA63FC58D88686C611ABA98D6B3"},
+		{k1, "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364140", "Satoshi Nakamoto", "33A19B60E25FB6F4435AF53A3D42D493644827367E6453928554F43E49AA6F90"},
+		{p256, "C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721", "sample", "A6E3C57DD01ABE90086538398355DD4C3B17AA873382B0F24D6129493D8AAD60"},
+	}
+	for i, v := range vectors {
+		k := rfc6979(hex_int(v.secret), sha256([]byte(v.message)), v.gen.n)()
+		if k.Cmp(hex_int(v.k)) != 0 {
+			t.Errorf("vector %v: k = %X", i, k)
+		}
+	}
+}
+
+func TestECDSASign(t *testing.T) {
+	for _, name := range CurveNames() {
+		gen, _ := LookupCurve(name)
+		for _, secret := range []*big.Int{big.NewInt(1), big.NewInt(2), new(big.Int).Sub(gen.n, big.NewInt(1))} {
+			message := []byte(name)
+			sig, err := ecdsa_sign(secret, gen, message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !verify(gen.base_multiply(secret), gen, message, sig) {
+				t.Fatalf("%v: signature by %v rejected", name, secret)
+			}
+			if sig.s.IsHigh() {
+				t.Fatalf("%v: high s", name)
+			}
+			again, _ := ecdsa_sign(secret, gen, message)
+			if !again.r.Equal(sig.r) || !again.s.Equal(sig.s) {
+				t.Fatalf("%v: signing is not deterministic", name)
+			}
+		}
+	}
+	gen, _ := LookupCurve("secp256k1")
+	// Different keys signing the same message use different nonces.
+	a, _ := ecdsa_sign(big.NewInt(5), gen, []byte("same"))
+	b, _ := ecdsa_sign(big.NewInt(6), gen, []byte("same"))
+	if a.r.Equal(b.r) {
+		t.Fatal("nonce reused across keys")
+	}
+	for _, k := range []*big.Int{big.NewInt(0), big.NewInt(-1), gen.n} {
+		if _, err := ecdsa_sign(k, gen, []byte("x")); err == nil {
+			t.Errorf("signed with secret key %v", k)
+		}
+	}
+}